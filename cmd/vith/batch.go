@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runBatch(ctx context.Context, config configuration, services services) int {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := services.vith.Batch(ctx, config.batch, config.args[0], config.args[1:], os.Stdout)
+	fmt.Fprint(os.Stderr, summary)
+
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "batch", slog.Any("error", err))
+		return 1
+	}
+
+	if len(summary.Failures) != 0 {
+		return 1
+	}
+
+	return 0
+}
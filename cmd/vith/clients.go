@@ -11,6 +11,7 @@ import (
 	"github.com/ViBiOh/httputils/v4/pkg/pprof"
 	"github.com/ViBiOh/httputils/v4/pkg/request"
 	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/vith"
 )
 
 type clients struct {
@@ -18,6 +19,8 @@ type clients struct {
 	pprof     *pprof.Service
 	health    *health.Service
 	amqp      *amqp.Client
+
+	capabilities vith.Capabilities
 }
 
 func newClients(ctx context.Context, config configuration) (clients, error) {
@@ -37,7 +40,9 @@ func newClients(ctx context.Context, config configuration) (clients, error) {
 	service, version, env := output.telemetry.GetServiceVersionAndEnv()
 	output.pprof = pprof.New(config.pprof, service, version, env)
 
-	output.health = health.New(ctx, config.health)
+	output.capabilities = vith.DetectCapabilities(ctx)
+
+	output.health = health.New(ctx, config.health, output.capabilities.Ping)
 
 	output.amqp, err = amqp.New(ctx, config.amqp, output.telemetry.MeterProvider(), output.telemetry.TracerProvider())
 	if err != nil && !errors.Is(err, amqp.ErrNoConfig) {
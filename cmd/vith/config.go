@@ -27,10 +27,15 @@ type configuration struct {
 	health    *health.Config
 
 	vith             *vith.Config
+	batch            *vith.BatchConfig
 	absto            *absto.Config
 	amqp             *amqp.Config
 	streamHandler    *amqphandler.Config
 	thumbnailHandler *amqphandler.Config
+	previewHandler   *amqphandler.Config
+	transcodeHandler *amqphandler.Config
+
+	args []string
 }
 
 func newConfig() configuration {
@@ -47,13 +52,18 @@ func newConfig() configuration {
 		server: server.Flags(fs, "", flags.NewOverride("ReadTimeout", 2*time.Minute), flags.NewOverride("WriteTimeout", 2*time.Minute)),
 
 		vith:             vith.Flags(fs, ""),
+		batch:            vith.BatchFlags(fs, "batch"),
 		absto:            absto.Flags(fs, "storage", flags.NewOverride("FileSystemDirectory", "")),
 		amqp:             amqp.Flags(fs, "amqp"),
 		streamHandler:    amqphandler.Flags(fs, "stream", flags.NewOverride("Exchange", "fibr"), flags.NewOverride("Queue", "stream"), flags.NewOverride("RoutingKey", "stream")),
 		thumbnailHandler: amqphandler.Flags(fs, "thumbnail", flags.NewOverride("Exchange", "fibr"), flags.NewOverride("Queue", "thumbnail"), flags.NewOverride("RoutingKey", "thumbnail")),
+		previewHandler:   amqphandler.Flags(fs, "preview", flags.NewOverride("Exchange", "fibr"), flags.NewOverride("Queue", "preview"), flags.NewOverride("RoutingKey", "preview")),
+		transcodeHandler: amqphandler.Flags(fs, "transcode", flags.NewOverride("Exchange", "fibr"), flags.NewOverride("Queue", "transcode"), flags.NewOverride("RoutingKey", "transcode")),
 	}
 
 	_ = fs.Parse(os.Args[1:])
 
+	config.args = fs.Args()
+
 	return config
 }
@@ -11,7 +11,13 @@ func newPort(clients clients, services services) http.Handler {
 
 	mux.HandleFunc("HEAD /", services.vith.HandleHead)
 	mux.HandleFunc("GET /", services.vith.HandleGet)
+	mux.HandleFunc("GET /_/clip/{input...}", services.vith.HandleClip)
+	mux.HandleFunc("GET /_/hash/{input...}", services.vith.HandleHash)
+	mux.HandleFunc("GET /_/compare", services.vith.HandleCompare)
+	mux.HandleFunc("GET /_/remote", services.vith.HandleRemote)
+	mux.HandleFunc("GET /_/capabilities", services.vith.HandleCapabilities)
 	mux.HandleFunc("POST /", services.vith.HandlePost)
+	mux.HandleFunc("POST /_/preview", services.vith.HandlePreview)
 	mux.HandleFunc("PUT /", services.vith.HandlePut)
 	mux.HandleFunc("PATCH /", services.vith.HandlePatch)
 	mux.HandleFunc("DELETE /", services.vith.HandleDelete)
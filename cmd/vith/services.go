@@ -13,6 +13,8 @@ type services struct {
 	server           *server.Server
 	streamHandler    *amqphandler.Service
 	thumbnailHandler *amqphandler.Service
+	previewHandler   *amqphandler.Service
+	transcodeHandler *amqphandler.Service
 	vith             vith.Service
 }
 
@@ -22,7 +24,10 @@ func newServices(config configuration, clients clients, adapters adapters) (serv
 
 	output.server = server.New(config.server)
 
-	output.vith = vith.New(config.vith, clients.amqp, adapters.storage, clients.telemetry.MeterProvider(), clients.telemetry.TracerProvider())
+	output.vith, err = vith.New(config.vith, clients.capabilities, clients.amqp, adapters.storage, clients.telemetry.MeterProvider(), clients.telemetry.TracerProvider())
+	if err != nil {
+		return output, fmt.Errorf("vith: %w", err)
+	}
 
 	output.streamHandler, err = amqphandler.New(config.streamHandler, clients.amqp, clients.telemetry.MeterProvider(), clients.telemetry.TracerProvider(), output.vith.AmqpStreamHandler)
 	if err != nil {
@@ -34,11 +39,24 @@ func newServices(config configuration, clients clients, adapters adapters) (serv
 		return output, fmt.Errorf("thumbnail: %w", err)
 	}
 
+	output.previewHandler, err = amqphandler.New(config.previewHandler, clients.amqp, clients.telemetry.MeterProvider(), clients.telemetry.TracerProvider(), output.vith.AmqpPreviewHandler)
+	if err != nil {
+		return output, fmt.Errorf("preview: %w", err)
+	}
+
+	output.transcodeHandler, err = amqphandler.New(config.transcodeHandler, clients.amqp, clients.telemetry.MeterProvider(), clients.telemetry.TracerProvider(), output.vith.AmqpTranscodeHandler)
+	if err != nil {
+		return output, fmt.Errorf("transcode: %w", err)
+	}
+
 	return output, nil
 }
 
 func (s services) Start(ctx context.Context) {
 	go s.streamHandler.Start(ctx)
 	go s.thumbnailHandler.Start(ctx)
+	go s.previewHandler.Start(ctx)
+	go s.transcodeHandler.Start(ctx)
 	go s.vith.Start(ctx)
+	go s.vith.SweepTmp(ctx)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 
 	"github.com/ViBiOh/httputils/v4/pkg/alcotest"
 	"github.com/ViBiOh/httputils/v4/pkg/health"
@@ -26,6 +27,12 @@ func main() {
 	services, err := newServices(config, clients, adapters)
 	logger.FatalfOnErr(ctx, err, "services")
 
+	if len(config.args) != 0 {
+		exitCode := runBatch(ctx, config, services)
+		clients.Close(ctx)
+		os.Exit(exitCode)
+	}
+
 	go services.Start(clients.health.DoneCtx())
 
 	port := newPort(clients, services)
@@ -33,5 +40,5 @@ func main() {
 	go services.server.Start(clients.health.EndCtx(), port)
 
 	clients.health.WaitForTermination(services.server.Done())
-	health.WaitAll(services.server.Done(), services.streamHandler.Done(), services.thumbnailHandler.Done())
+	health.WaitAll(services.server.Done(), services.streamHandler.Done(), services.thumbnailHandler.Done(), services.previewHandler.Done(), services.transcodeHandler.Done())
 }
@@ -15,10 +15,14 @@ const (
 	TypeVideo ItemType = iota
 	// TypeImage image type
 	TypeImage
+	// TypeAudio audio type
+	TypeAudio
+	// TypeDocument document type
+	TypeDocument
 )
 
 // ItemTypeValues string values
-var ItemTypeValues = []string{"video", "image"}
+var ItemTypeValues = []string{"video", "image", "audio", "document"}
 
 // ParseItemType parse raw string into a ItemType
 func ParseItemType(value string) (ItemType, error) {
@@ -31,6 +35,11 @@ func ParseItemType(value string) (ItemType, error) {
 	return TypeVideo, fmt.Errorf("invalid value `%s` for item type", value)
 }
 
+// IsStreamable checks if item type can be converted to HLS
+func (it ItemType) IsStreamable() bool {
+	return it == TypeVideo || it == TypeAudio
+}
+
 func (it ItemType) String() string {
 	return ItemTypeValues[it]
 }
@@ -59,12 +68,103 @@ func (it *ItemType) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// RequestMode of stream generation
+type RequestMode int
+
+const (
+	// ModeStream HLS stream
+	ModeStream RequestMode = iota
+	// ModeTranscode progressive MP4
+	ModeTranscode
+)
+
+// RequestModeValues string values
+var RequestModeValues = []string{"stream", "transcode"}
+
+// ParseRequestMode parse raw string into a RequestMode
+func ParseRequestMode(value string) (RequestMode, error) {
+	for i, short := range RequestModeValues {
+		if strings.EqualFold(short, value) {
+			return RequestMode(i), nil
+		}
+	}
+
+	return ModeStream, fmt.Errorf("invalid value `%s` for request mode", value)
+}
+
+func (rm RequestMode) String() string {
+	return RequestModeValues[rm]
+}
+
+// MarshalJSON marshals the enum as a quoted json string
+func (rm RequestMode) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(rm.String())
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalJSON unmarshal JSON
+func (rm *RequestMode) UnmarshalJSON(b []byte) error {
+	var strValue string
+	if err := json.Unmarshal(b, &strValue); err != nil {
+		return fmt.Errorf("unmarshal request mode: %w", err)
+	}
+
+	value, err := ParseRequestMode(strValue)
+	if err != nil {
+		return fmt.Errorf("parse request mode: %w", err)
+	}
+
+	*rm = value
+	return nil
+}
+
+// Overlay describes a watermark drawn over outputs
+type Overlay struct {
+	Image    string  `json:"image,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Scale    float64 `json:"scale,omitempty"`
+	Disabled bool    `json:"disabled,omitempty"`
+}
+
+// IsZero checks if overlay has something to draw
+func (o Overlay) IsZero() bool {
+	return len(o.Image) == 0 && len(o.Text) == 0
+}
+
+// Hash is the perceptual hash of an item, as hexadecimal strings
+type Hash struct {
+	DHash  string   `json:"dhash,omitempty"`
+	PHash  string   `json:"phash,omitempty"`
+	Frames []string `json:"frames,omitempty"`
+}
+
+// Placeholder describes a thumbnail to render while it loads
+type Placeholder struct {
+	BlurHash string   `json:"blurhash"`
+	Average  string   `json:"average"`
+	Palette  []string `json:"palette,omitempty"`
+}
+
 // Request for generating stream
 type Request struct {
-	Input    string   `json:"input"`
-	Output   string   `json:"output"`
-	Scale    uint64   `json:"scale"`
-	ItemType ItemType `json:"type"`
+	Overlay     *Overlay     `json:"overlay,omitempty"`
+	Hash        *Hash        `json:"hash,omitempty"`
+	Placeholder *Placeholder `json:"placeholder,omitempty"`
+	Input       string       `json:"input"`
+	Output      string       `json:"output"`
+	Scale       uint64       `json:"scale"`
+	Page        uint64       `json:"page,omitempty"`
+	Duration    float64      `json:"duration,omitempty"`
+	Fps         uint64       `json:"fps,omitempty"`
+	Segments    uint64       `json:"segments,omitempty"`
+	Height      uint64       `json:"height,omitempty"`
+	Bitrate     uint64       `json:"bitrate,omitempty"`
+	ItemType    ItemType     `json:"type"`
+	Mode        RequestMode  `json:"mode,omitempty"`
 }
 
 // NewRequest creates a new request
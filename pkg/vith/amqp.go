@@ -29,9 +29,9 @@ func (s Service) AmqpStreamHandler(ctx context.Context, message amqp.Delivery) e
 		return fmt.Errorf("parse payload: %w", err)
 	}
 
-	if req.ItemType != model.TypeVideo {
+	if !req.ItemType.IsStreamable() {
 		s.increaseMetric(ctx, "amqp", "stream", req.ItemType.String(), "forbidden")
-		return errors.New("stream are possible for video type only")
+		return errors.New("stream are possible for video or audio type only")
 	}
 
 	if len(req.Input) == 0 {
@@ -75,11 +75,15 @@ func (s Service) AmqpThumbnailHandler(ctx context.Context, message amqp.Delivery
 		return fmt.Errorf("parse payload: %w", err)
 	}
 
-	if err = s.storageThumbnail(ctx, req.ItemType, req.Input, req.Output, req.Scale); err != nil {
+	metadata, err := s.storageThumbnail(ctx, req.ItemType, req.Input, req.Output, newThumbnailOptions(req.Scale, req.Page, s.overlayOrDefault(req.Overlay)))
+	if err != nil {
 		s.increaseMetric(ctx, "amqp", "thumbnail", req.ItemType.String(), "error")
 		return err
 	}
 
+	req.Hash = metadata.hash
+	req.Placeholder = metadata.placeholder
+
 	if err = s.amqpClient.PublishJSON(ctx, req, s.amqpExchange, s.amqpRoutingKey); err != nil {
 		return fmt.Errorf("publish amqp message: %w", err)
 	}
@@ -0,0 +1,142 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+type audioInfo struct {
+	Tags     map[string]string
+	Codec    string
+	Bitrate  int64
+	Duration float64
+}
+
+func (s Service) audioThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) error {
+	var err error
+
+	scale := options.scale
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_audio_thumbnail")
+	defer end(&err)
+
+	hasCover, coverErr := s.hasCoverArt(ctx, inputName)
+	if coverErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get cover art", slog.String("input", inputName), slog.Any("error", coverErr))
+	}
+
+	if hasCover {
+		err = s.imageThumbnail(ctx, inputName, outputName, options)
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", inputName, "-map_metadata", "-1", "-filter_complex", fmt.Sprintf("aformat=channel_layouts=mono,showwavespic=s=%dx%d:colors=white", scale, scale), "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(scale), "-an", "-preset", "picture", "-y", "-f", "webp", "-frames:v", "1", outputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return fmt.Errorf("ffmpeg audio: %s: %w", buffer.String(), err)
+	}
+
+	return nil
+}
+
+func (s Service) hasCoverArt(ctx context.Context, inputName string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v", "-show_entries", "stream_disposition=attached_pic", "-of", "json", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err := s.runCommand(ctx, cmd); err != nil {
+		return false, fmt.Errorf("ffprobe cover: %w", err)
+	}
+
+	var output struct {
+		Streams []struct {
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+
+	if err := json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		return false, fmt.Errorf("parse ffprobe cover: %w", err)
+	}
+
+	for _, stream := range output.Streams {
+		if stream.Disposition.AttachedPic == 1 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s Service) getAudioDetails(ctx context.Context, inputName string) (info audioInfo, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffprobe_audio")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "a:0", "-show_entries", "stream=codec_name,bit_rate:format=duration,bit_rate:format_tags", "-of", "json", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return info, fmt.Errorf("ffprobe audio: %w", err)
+	}
+
+	var output struct {
+		Format struct {
+			Tags     map[string]string `json:"tags"`
+			Duration string            `json:"duration"`
+			Bitrate  string            `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			Codec   string `json:"codec_name"`
+			Bitrate string `json:"bit_rate"`
+		} `json:"streams"`
+	}
+
+	if err = json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		return info, fmt.Errorf("parse ffprobe audio: %w", err)
+	}
+
+	info.Tags = output.Format.Tags
+	info.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+
+	bitrate := output.Format.Bitrate
+	if len(output.Streams) > 0 {
+		info.Codec = output.Streams[0].Codec
+
+		if len(output.Streams[0].Bitrate) > 0 {
+			bitrate = output.Streams[0].Bitrate
+		}
+	}
+
+	info.Bitrate, _ = strconv.ParseInt(bitrate, 10, 64)
+
+	return info, nil
+}
+
+func streamAudioOptions() []string {
+	return []string{"-vn"}
+}
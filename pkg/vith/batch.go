@@ -0,0 +1,421 @@
+package vith
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/flags"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	BatchThumbnail = "thumbnail"
+	BatchStream    = "stream"
+	BatchProbe     = "probe"
+	BatchReconcile = "reconcile"
+
+	// LayoutMirror writes outputs at the same path as their input, with the output extension
+	LayoutMirror = "mirror"
+	// LayoutFibr writes outputs in the directory of their input, named by the ID of the input, as fibr does in its metadata folder
+	LayoutFibr = "fibr"
+
+	webpExtension = ".webp"
+)
+
+var extensionItemTypes = map[string]model.ItemType{
+	".3gp":  model.TypeVideo,
+	".avi":  model.TypeVideo,
+	".flv":  model.TypeVideo,
+	".m4v":  model.TypeVideo,
+	".mkv":  model.TypeVideo,
+	".mov":  model.TypeVideo,
+	".mp4":  model.TypeVideo,
+	".mpeg": model.TypeVideo,
+	".mpg":  model.TypeVideo,
+	".webm": model.TypeVideo,
+	".wmv":  model.TypeVideo,
+
+	".arw":  model.TypeImage,
+	".avif": model.TypeImage,
+	".bmp":  model.TypeImage,
+	".cr2":  model.TypeImage,
+	".cr3":  model.TypeImage,
+	".dng":  model.TypeImage,
+	".gif":  model.TypeImage,
+	".heic": model.TypeImage,
+	".heif": model.TypeImage,
+	".jpeg": model.TypeImage,
+	".jpg":  model.TypeImage,
+	".nef":  model.TypeImage,
+	".orf":  model.TypeImage,
+	".png":  model.TypeImage,
+	".raf":  model.TypeImage,
+	".rw2":  model.TypeImage,
+	".tif":  model.TypeImage,
+	".tiff": model.TypeImage,
+	".webp": model.TypeImage,
+
+	".aac":  model.TypeAudio,
+	".flac": model.TypeAudio,
+	".m4a":  model.TypeAudio,
+	".mp3":  model.TypeAudio,
+	".ogg":  model.TypeAudio,
+	".opus": model.TypeAudio,
+	".wav":  model.TypeAudio,
+
+	".pdf": model.TypeDocument,
+}
+
+type BatchConfig struct {
+	Output              string
+	Layout              string
+	ThumbnailRoutingKey string
+	StreamRoutingKey    string
+	Include             []string
+	Exclude             []string
+	Parallel            uint
+	Overwrite           bool
+	Streams             bool
+	Enqueue             bool
+	Prune               bool
+}
+
+func BatchFlags(fs *flag.FlagSet, prefix string, overrides ...flags.Override) *BatchConfig {
+	var config BatchConfig
+
+	flags.New("Output", "Storage folder where outputs are written, mirroring input paths").Prefix(prefix).DocPrefix("batch").StringVar(fs, &config.Output, "/.vith", overrides)
+	flags.New("Include", "Glob patterns of files to process, on name or path, all if empty").Prefix(prefix).DocPrefix("batch").StringSliceVar(fs, &config.Include, nil, overrides)
+	flags.New("Exclude", "Glob patterns of files to skip, on name or path").Prefix(prefix).DocPrefix("batch").StringSliceVar(fs, &config.Exclude, nil, overrides)
+	flags.New("Parallel", "Number of files processed in parallel").Prefix(prefix).DocPrefix("batch").UintVar(fs, &config.Parallel, 2, overrides)
+	flags.New("Overwrite", "Process files even if their output already exists").Prefix(prefix).DocPrefix("batch").BoolVar(fs, &config.Overwrite, false, overrides)
+	flags.New("Layout", "Naming of outputs in the output folder: mirror or fibr").Prefix(prefix).DocPrefix("batch").StringVar(fs, &config.Layout, LayoutMirror, overrides)
+	flags.New("Streams", "Reconcile reports videos and audios without stream").Prefix(prefix).DocPrefix("batch").BoolVar(fs, &config.Streams, false, overrides)
+	flags.New("Enqueue", "Reconcile generates missing outputs, through AMQP if configured, in process otherwise").Prefix(prefix).DocPrefix("batch").BoolVar(fs, &config.Enqueue, false, overrides)
+	flags.New("Prune", "Reconcile deletes orphan outputs").Prefix(prefix).DocPrefix("batch").BoolVar(fs, &config.Prune, false, overrides)
+	flags.New("ThumbnailRoutingKey", "AMQP Routing Key of thumbnail generation").Prefix(prefix).DocPrefix("batch").StringVar(fs, &config.ThumbnailRoutingKey, "thumbnail", overrides)
+	flags.New("StreamRoutingKey", "AMQP Routing Key of stream generation").Prefix(prefix).DocPrefix("batch").StringVar(fs, &config.StreamRoutingKey, "stream", overrides)
+
+	return &config
+}
+
+type BatchSummary struct {
+	Failures  map[string]error
+	Duration  time.Duration
+	Processed int
+	Skipped   int
+	Gaps      int
+	Orphans   int
+}
+
+func (bs BatchSummary) String() string {
+	var output strings.Builder
+
+	if bs.Gaps != 0 || bs.Orphans != 0 {
+		fmt.Fprintf(&output, "%d gaps, %d orphans\n", bs.Gaps, bs.Orphans)
+	}
+
+	fmt.Fprintf(&output, "%d processed, %d skipped, %d failed in %s\n", bs.Processed, bs.Skipped, len(bs.Failures), bs.Duration.Round(time.Millisecond))
+
+	failures := make([]string, 0, len(bs.Failures))
+	for pathname := range bs.Failures {
+		failures = append(failures, pathname)
+	}
+
+	slices.Sort(failures)
+
+	for _, pathname := range failures {
+		fmt.Fprintf(&output, "  %s: %s\n", pathname, bs.Failures[pathname])
+	}
+
+	return output.String()
+}
+
+type batchItem struct {
+	pathname string
+	itemType model.ItemType
+}
+
+type probeResult struct {
+	Tags      map[string]string `json:"tags,omitempty"`
+	Path      string            `json:"path"`
+	Codec     string            `json:"codec,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Audios    []mediaTrack      `json:"audios,omitempty"`
+	Subtitles []mediaTrack      `json:"subtitles,omitempty"`
+	Bitrate   int64             `json:"bitrate,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Pages     uint64            `json:"pages,omitempty"`
+	Width     int               `json:"width,omitempty"`
+	Height    int               `json:"height,omitempty"`
+	Type      model.ItemType    `json:"type"`
+}
+
+// Batch runs the command on every file of the given storage paths, probe results are written to the writer as JSON lines
+func (s Service) Batch(ctx context.Context, config *BatchConfig, command string, paths []string, writer io.Writer) (summary BatchSummary, err error) {
+	summary.Failures = make(map[string]error)
+
+	if !s.storage.Enabled() {
+		return summary, errors.New("batch requires a storage")
+	}
+
+	if command != BatchThumbnail && command != BatchStream && command != BatchProbe && command != BatchReconcile {
+		return summary, fmt.Errorf("unknown command `%s`, expected %s, %s, %s or %s", command, BatchThumbnail, BatchStream, BatchProbe, BatchReconcile)
+	}
+
+	if config.Layout != LayoutMirror && config.Layout != LayoutFibr {
+		return summary, fmt.Errorf("unknown layout `%s`, expected %s or %s", config.Layout, LayoutMirror, LayoutFibr)
+	}
+
+	if len(paths) == 0 {
+		return summary, errors.New("at least one path is required")
+	}
+
+	// the results are named so the duration is set on the returned summary
+	start := time.Now()
+	defer func() {
+		summary.Duration = time.Since(start)
+	}()
+
+	if command == BatchReconcile {
+		if err = s.reconcile(ctx, config, paths, writer, &summary); err != nil {
+			return summary, err
+		}
+
+		return summary, ctx.Err()
+	}
+
+	items, skipped, err := s.batchItems(ctx, config, command, paths)
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Skipped = skipped
+
+	var mutex sync.Mutex
+
+	batchRun(ctx, config, items, func(item batchItem) string { return item.pathname }, func(item batchItem) (bool, error) {
+		return s.batchProcess(ctx, config, command, item, writer, &mutex)
+	}, &summary)
+
+	return summary, ctx.Err()
+}
+
+// batchRun calls the handler on every item, with the configured parallelism, and counts results in the summary
+func batchRun[T any](ctx context.Context, config *BatchConfig, items []T, key func(T) string, handler func(T) (bool, error), summary *BatchSummary) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, max(config.Parallel, 1))
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+
+		semaphore <- struct{}{}
+		wg.Add(1)
+
+		go func(item T) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			done, err := handler(item)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			switch {
+			case err != nil:
+				summary.Failures[key(item)] = err
+			case done:
+				summary.Processed++
+			default:
+				summary.Skipped++
+			}
+		}(item)
+	}
+
+	wg.Wait()
+}
+
+func (s Service) batchItems(ctx context.Context, config *BatchConfig, command string, paths []string) (items []batchItem, skipped int, err error) {
+	for _, root := range paths {
+		err = s.storage.Walk(ctx, root, func(item absto.Item) error {
+			if item.IsDir() || isHidden(item.Pathname) || strings.HasPrefix(item.Pathname, absto.Dirname(config.Output)) {
+				return nil
+			}
+
+			itemType, ok := extensionItemTypes[strings.ToLower(path.Ext(item.Pathname))]
+			if !ok || !matchBatchGlobs(config, item.Pathname) || (command == BatchStream && !itemType.IsStreamable()) {
+				skipped++
+				return nil
+			}
+
+			items = append(items, batchItem{pathname: item.Pathname, itemType: itemType})
+
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("walk `%s`: %w", root, err)
+		}
+	}
+
+	return items, skipped, nil
+}
+
+// batchProcess handles a single file, returning false if it has been skipped
+func (s Service) batchProcess(ctx context.Context, config *BatchConfig, command string, item batchItem, writer io.Writer, mutex sync.Locker) (bool, error) {
+	log := slog.With("input", item.pathname).With("command", command)
+
+	switch command {
+	case BatchProbe:
+		result := s.probe(ctx, item)
+
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return false, fmt.Errorf("marshal probe: %w", err)
+		}
+
+		mutex.Lock()
+		_, err = fmt.Fprintln(writer, string(payload))
+		mutex.Unlock()
+
+		if len(result.Error) > 0 {
+			return false, errors.New(result.Error)
+		}
+
+		return true, err
+
+	case BatchThumbnail:
+		output := batchOutput(config, item.pathname, webpExtension)
+		if !config.Overwrite && s.exists(ctx, output) {
+			return false, nil
+		}
+
+		log.InfoContext(ctx, "Generating thumbnail...")
+
+		_, err := s.storageThumbnail(ctx, item.itemType, item.pathname, output, newThumbnailOptions(0, 0, s.overlayOrDefault(nil)))
+		return err == nil, err
+
+	default:
+		output := batchOutput(config, item.pathname, hlsExtension)
+		if !config.Overwrite && s.exists(ctx, output) {
+			return false, nil
+		}
+
+		if err := s.storage.Mkdir(ctx, path.Dir(output), absto.DirectoryPerm); err != nil {
+			return false, fmt.Errorf("create directory for output: %w", err)
+		}
+
+		err := s.generateStream(ctx, model.NewRequest(item.pathname, output, item.itemType, defaultScale))
+		return err == nil, err
+	}
+}
+
+func (s Service) probe(ctx context.Context, item batchItem) (result probeResult) {
+	result.Path = item.pathname
+	result.Type = item.itemType
+
+	inputName, finalizeInput, err := s.getInputName(ctx, item.pathname)
+	if err != nil {
+		result.Error = fmt.Sprintf("get input name: %s", err)
+		return
+	}
+	defer finalizeInput()
+
+	ctx, done := s.startJob(ctx, "probe", item.itemType)
+	defer done()
+
+	switch item.itemType {
+	case model.TypeAudio:
+		var info audioInfo
+		info, err = s.getAudioDetails(ctx, inputName)
+
+		result.Tags = info.Tags
+		result.Codec = info.Codec
+		result.Bitrate = info.Bitrate
+		result.Duration = info.Duration
+
+	case model.TypeDocument:
+		result.Pages, err = s.getDocumentPages(ctx, inputName)
+
+	default:
+		var info mediaInfo
+		info, err = s.getMediaInfo(ctx, inputName)
+
+		result.Width = info.Width
+		result.Height = info.Height
+
+		if err == nil && item.itemType == model.TypeVideo {
+			var audioErr, subtitlesErr error
+
+			result.Bitrate, result.Duration, err = s.getVideoDetails(ctx, inputName)
+			result.Audios, audioErr = s.getTracks(ctx, inputName, "a")
+			result.Subtitles, subtitlesErr = s.getTracks(ctx, inputName, "s")
+
+			err = errors.Join(err, audioErr, subtitlesErr)
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (s Service) exists(ctx context.Context, name string) bool {
+	_, err := s.storage.Stat(ctx, name)
+	return err == nil
+}
+
+func batchOutput(config *BatchConfig, pathname, extension string) string {
+	if config.Layout == LayoutFibr {
+		return path.Join(config.Output, path.Dir(pathname), absto.ID(pathname)+extension)
+	}
+
+	return path.Join(config.Output, strings.TrimSuffix(pathname, path.Ext(pathname))+extension)
+}
+
+func matchBatchGlobs(config *BatchConfig, pathname string) bool {
+	if len(config.Include) > 0 && !matchGlobs(config.Include, pathname) {
+		return false
+	}
+
+	return !matchGlobs(config.Exclude, pathname)
+}
+
+func matchGlobs(patterns []string, pathname string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, path.Base(pathname)); matched {
+			return true
+		}
+
+		if matched, _ := path.Match(pattern, pathname); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHidden(pathname string) bool {
+	for _, part := range strings.Split(pathname, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+
+	return false
+}
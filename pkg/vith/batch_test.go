@@ -0,0 +1,349 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ViBiOh/absto/pkg/filesystem"
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+func batchStorage(t *testing.T, names ...string) Service {
+	t.Helper()
+
+	root := t.TempDir()
+
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, err := filesystem.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Service{storage: storage}
+}
+
+func TestBatchOutput(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		config    *BatchConfig
+		pathname  string
+		extension string
+		want      string
+	}{
+		"mirror": {
+			&BatchConfig{Output: "/.vith", Layout: LayoutMirror},
+			"/videos/holidays/beach.mp4",
+			hlsExtension,
+			"/.vith/videos/holidays/beach.m3u8",
+		},
+		"mirror dotted name": {
+			&BatchConfig{Output: "/.vith/", Layout: LayoutMirror},
+			"/photos/2024.01.01.jpg",
+			webpExtension,
+			"/.vith/photos/2024.01.01.webp",
+		},
+		"mirror no extension": {
+			&BatchConfig{Output: "/thumbnails", Layout: LayoutMirror},
+			"/photos/scan",
+			webpExtension,
+			"/thumbnails/photos/scan.webp",
+		},
+		"fibr": {
+			&BatchConfig{Output: "/.fibr", Layout: LayoutFibr},
+			"/photos/image.jpg",
+			webpExtension,
+			"/.fibr/photos/" + absto.ID("/photos/image.jpg") + webpExtension,
+		},
+		"fibr root": {
+			&BatchConfig{Output: "/.fibr", Layout: LayoutFibr},
+			"/video.mp4",
+			hlsExtension,
+			"/.fibr/" + absto.ID("/video.mp4") + hlsExtension,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := batchOutput(testCase.config, testCase.pathname, testCase.extension); got != testCase.want {
+				t.Errorf("batchOutput() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMatchBatchGlobs(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		config   *BatchConfig
+		pathname string
+		want     bool
+	}{
+		"no glob": {
+			&BatchConfig{},
+			"/photos/image.jpg",
+			true,
+		},
+		"include name": {
+			&BatchConfig{Include: []string{"*.jpg"}},
+			"/photos/image.jpg",
+			true,
+		},
+		"include other": {
+			&BatchConfig{Include: []string{"*.png"}},
+			"/photos/image.jpg",
+			false,
+		},
+		"include path": {
+			&BatchConfig{Include: []string{"/photos/*"}},
+			"/photos/image.jpg",
+			true,
+		},
+		"include path not recursive": {
+			&BatchConfig{Include: []string{"/photos/*"}},
+			"/photos/2024/image.jpg",
+			false,
+		},
+		"exclude name": {
+			&BatchConfig{Exclude: []string{"draft_*"}},
+			"/photos/draft_image.jpg",
+			false,
+		},
+		"exclude over include": {
+			&BatchConfig{Include: []string{"*.jpg"}, Exclude: []string{"/photos/private/*"}},
+			"/photos/private/image.jpg",
+			false,
+		},
+		"invalid pattern": {
+			&BatchConfig{Include: []string{"[.jpg"}},
+			"/photos/image.jpg",
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := matchBatchGlobs(testCase.config, testCase.pathname); got != testCase.want {
+				t.Errorf("matchBatchGlobs() = %t, want %t", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestBatchItems(t *testing.T) {
+	t.Parallel()
+
+	service := batchStorage(t,
+		"media/image.jpg",
+		"media/video.mp4",
+		"media/song.mp3",
+		"media/notes.txt",
+		"media/.hidden.jpg",
+		"media/.trash/old.jpg",
+		"media/draft_video.mp4",
+		"thumbs/media/image.webp",
+	)
+
+	cases := map[string]struct {
+		config      *BatchConfig
+		command     string
+		want        []batchItem
+		wantSkipped int
+	}{
+		"thumbnail": {
+			&BatchConfig{Output: "/thumbs"},
+			BatchThumbnail,
+			[]batchItem{
+				{pathname: "/media/draft_video.mp4", itemType: model.TypeVideo},
+				{pathname: "/media/image.jpg", itemType: model.TypeImage},
+				{pathname: "/media/song.mp3", itemType: model.TypeAudio},
+				{pathname: "/media/video.mp4", itemType: model.TypeVideo},
+			},
+			1,
+		},
+		"stream": {
+			&BatchConfig{Output: "/thumbs"},
+			BatchStream,
+			[]batchItem{
+				{pathname: "/media/draft_video.mp4", itemType: model.TypeVideo},
+				{pathname: "/media/song.mp3", itemType: model.TypeAudio},
+				{pathname: "/media/video.mp4", itemType: model.TypeVideo},
+			},
+			2,
+		},
+		"globs": {
+			&BatchConfig{Output: "/thumbs", Include: []string{"*.mp4"}, Exclude: []string{"draft_*"}},
+			BatchThumbnail,
+			[]batchItem{
+				{pathname: "/media/video.mp4", itemType: model.TypeVideo},
+			},
+			4,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, skipped, err := service.batchItems(context.Background(), testCase.config, testCase.command, []string{"/"})
+			if err != nil {
+				t.Fatalf("batchItems() error = %s", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("batchItems() = %v, want %v", got, testCase.want)
+			}
+
+			if skipped != testCase.wantSkipped {
+				t.Errorf("batchItems() skipped = %d, want %d", skipped, testCase.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestBatchRun(t *testing.T) {
+	t.Parallel()
+
+	errFailed := errors.New("failed")
+
+	cases := map[string]struct {
+		results       []error
+		skip          map[int]bool
+		parallel      uint
+		cancelled     bool
+		wantProcessed int
+		wantSkipped   int
+		wantFailures  int
+	}{
+		"all processed": {
+			make([]error, 5),
+			nil,
+			2,
+			false,
+			5,
+			0,
+			0,
+		},
+		"mixed": {
+			[]error{nil, errFailed, nil, nil, errFailed, nil},
+			map[int]bool{2: true, 3: true},
+			3,
+			false,
+			2,
+			2,
+			2,
+		},
+		"sequential": {
+			[]error{nil, errFailed, nil},
+			nil,
+			0,
+			false,
+			2,
+			0,
+			1,
+		},
+		"cancelled": {
+			make([]error, 4),
+			nil,
+			2,
+			true,
+			0,
+			0,
+			0,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if testCase.cancelled {
+				cancel()
+			}
+
+			items := make([]int, len(testCase.results))
+			for index := range items {
+				items[index] = index
+			}
+
+			var running, maxRunning atomic.Int32
+
+			summary := BatchSummary{Failures: make(map[string]error)}
+
+			batchRun(ctx, &BatchConfig{Parallel: testCase.parallel}, items, strconv.Itoa, func(item int) (bool, error) {
+				current := running.Add(1)
+				defer running.Add(-1)
+
+				for {
+					previous := maxRunning.Load()
+					if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+
+				time.Sleep(time.Millisecond)
+
+				return !testCase.skip[item], testCase.results[item]
+			}, &summary)
+
+			if summary.Processed != testCase.wantProcessed || summary.Skipped != testCase.wantSkipped || len(summary.Failures) != testCase.wantFailures {
+				t.Errorf("batchRun() = %d processed, %d skipped, %d failed, want %d, %d, %d", summary.Processed, summary.Skipped, len(summary.Failures), testCase.wantProcessed, testCase.wantSkipped, testCase.wantFailures)
+			}
+
+			if limit := int32(max(testCase.parallel, 1)); maxRunning.Load() > limit {
+				t.Errorf("batchRun() ran %d items at once, want at most %d", maxRunning.Load(), limit)
+			}
+
+			for key, err := range summary.Failures {
+				index, _ := strconv.Atoi(key)
+				if !errors.Is(err, testCase.results[index]) {
+					t.Errorf("failure of `%s` = %v, want %v", key, err, testCase.results[index])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchDuration(t *testing.T) {
+	t.Parallel()
+
+	service := batchStorage(t, "media/notes.txt")
+
+	summary, err := service.Batch(context.Background(), &BatchConfig{Output: "/.vith", Layout: LayoutMirror, Parallel: 1}, BatchThumbnail, []string{"/media"}, nil)
+	if err != nil {
+		t.Fatalf("Batch() error = %s", err)
+	}
+
+	if summary.Duration <= 0 {
+		t.Errorf("Batch() duration = %s, want a positive duration", summary.Duration)
+	}
+
+	if summary.Skipped != 1 {
+		t.Errorf("Batch() skipped = %d, want 1", summary.Skipped)
+	}
+}
@@ -0,0 +1,251 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/vith/pkg/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrTmpBudget occurs when a job can't reserve the temporary disk space it needs in time
+var ErrTmpBudget = errors.New("not enough temporary disk space")
+
+// errLengthRequired occurs when a body of unknown length can't be reserved
+var errLengthRequired = errors.New("body of unknown length")
+
+// tmpBudget accounts the temporary disk space reserved by running jobs, a job waits for others to release theirs before being rejected
+type tmpBudget struct {
+	released   chan struct{}
+	free       func(string) (uint64, error)
+	folder     string
+	workFolder string
+	mutex      sync.Mutex
+	limit      uint64
+	bodySize   uint64
+	reserved   uint64
+	wait       time.Duration
+}
+
+func newTmpBudget(folder, workFolder string, limit, bodySize uint64, wait time.Duration) *tmpBudget {
+	return &tmpBudget{
+		folder:     folder,
+		workFolder: workFolder,
+		limit:      limit,
+		bodySize:   bodySize,
+		wait:       wait,
+		released:   make(chan struct{}),
+		free:       freeSpace,
+	}
+}
+
+func (tb *tmpBudget) current() uint64 {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	return tb.reserved
+}
+
+// tryReserve reserves the size if it fits in the budget and in the free space of the folder. The part of the reservations not yet written
+// by running jobs is counted as used, the written one being already out of the free space.
+// It returns the channel closed on next release otherwise, and false if the size will never fit.
+func (tb *tmpBudget) tryReserve(size uint64) (bool, <-chan struct{}, error) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if tb.limit != 0 && size > tb.limit {
+		return false, nil, fmt.Errorf("%w: %d bytes needed, budget is %d bytes", ErrTmpBudget, size, tb.limit)
+	}
+
+	free, err := tb.free(tb.folder)
+	if err != nil {
+		return false, nil, fmt.Errorf("get free space of `%s`: %w", tb.folder, err)
+	}
+
+	if free != 0 && size > free && tb.reserved == 0 {
+		return false, nil, fmt.Errorf("%w: %d bytes needed, %d bytes free", ErrTmpBudget, size, free)
+	}
+
+	pending := tb.reserved - min(tb.reserved, usedSpace(tb.workFolder))
+
+	if (tb.limit != 0 && tb.reserved+size > tb.limit) || (free != 0 && pending+size > free) {
+		return false, tb.released, nil
+	}
+
+	tb.reserved += size
+
+	return true, nil, nil
+}
+
+// usedSpace sums the size of the files in the folder
+func usedSpace(folder string) (used uint64) {
+	if len(folder) == 0 {
+		return 0
+	}
+
+	_ = filepath.WalkDir(folder, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			// files removed while walking are released
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil {
+			used += uint64(info.Size())
+		}
+
+		return nil
+	})
+
+	return used
+}
+
+func (tb *tmpBudget) release(size uint64) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.reserved -= size
+
+	close(tb.released)
+	tb.released = make(chan struct{})
+}
+
+// reserveTmp reserves temporary disk space for the job, waiting up to the configured duration for other jobs to release theirs
+func (s Service) reserveTmp(ctx context.Context, size uint64) (func(), error) {
+	if s.tmpBudget == nil || size == 0 {
+		return noopFunc, nil
+	}
+
+	var timeout <-chan time.Time
+	state := "accepted"
+
+	for {
+		ok, released, err := s.tmpBudget.tryReserve(size)
+		if err != nil {
+			s.recordAdmission(ctx, "rejected")
+			return noopFunc, err
+		}
+
+		if ok {
+			s.recordAdmission(ctx, state)
+
+			var once sync.Once
+			return func() { once.Do(func() { s.tmpBudget.release(size) }) }, nil
+		}
+
+		if timeout == nil {
+			slog.LogAttrs(ctx, slog.LevelWarn, "Waiting for temporary disk space...", slog.Uint64("size", size), slog.Uint64("reserved", s.tmpBudget.current()))
+
+			timer := time.NewTimer(s.tmpBudget.wait)
+			defer timer.Stop()
+
+			timeout = timer.C
+			state = "delayed"
+		}
+
+		select {
+		case <-released:
+		case <-timeout:
+			s.recordAdmission(ctx, "rejected")
+			return noopFunc, fmt.Errorf("%w: %d bytes needed, %d bytes reserved by running jobs after waiting %s", ErrTmpBudget, size, s.tmpBudget.current(), s.tmpBudget.wait)
+		case <-ctx.Done():
+			return noopFunc, ctx.Err()
+		}
+	}
+}
+
+// reserveBody reserves the temporary disk space of the request body. A body of unknown length reserves the configured size and is limited to it,
+// or is refused if that size is 0.
+func (s Service) reserveBody(ctx context.Context, w http.ResponseWriter, r *http.Request) (func(), error) {
+	if s.tmpBudget == nil || r.ContentLength >= 0 {
+		return s.reserveTmp(ctx, uint64(max(r.ContentLength, 0)))
+	}
+
+	if s.tmpBudget.bodySize == 0 {
+		return noopFunc, errLengthRequired
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.tmpBudget.bodySize))
+
+	return s.reserveTmp(ctx, s.tmpBudget.bodySize)
+}
+
+// streamTmpEstimate bounds the size of the stream files from the bitrate and duration of the input, or from its size if probing fails
+func (s Service) streamTmpEstimate(ctx context.Context, inputName string, itemType model.ItemType) uint64 {
+	var estimate uint64
+
+	if itemType == model.TypeAudio {
+		if info, err := s.getAudioDetails(ctx, inputName); err == nil {
+			estimate = uint64(info.Duration * streamAudioBandwidth / 8)
+		}
+	} else if bitrate, duration, err := s.getVideoDetails(ctx, inputName); err == nil {
+		estimate = uint64(float64(bitrate+streamAudioBandwidth) * duration / 8)
+
+		if s.streamHdr {
+			estimate *= 2
+		}
+	}
+
+	if estimate == 0 {
+		return fileSize(inputName)
+	}
+
+	return estimate
+}
+
+func fileSize(name string) uint64 {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+
+	return uint64(info.Size())
+}
+
+func (s Service) recordAdmission(ctx context.Context, state string) {
+	if s.metrics.tmpAdmission == nil {
+		return
+	}
+
+	s.metrics.tmpAdmission.Add(ctx, 1, operationAttributes(ctx, attribute.String("state", state)))
+}
+
+func observeTmpReserved(budget *tmpBudget) metric.Int64Callback {
+	return func(_ context.Context, observer metric.Int64Observer) error {
+		if budget != nil {
+			observer.Observe(int64(budget.current()))
+		}
+
+		return nil
+	}
+}
+
+// handleJobError answers 503 when the job has been rejected for lack of temporary disk space, 411 when the body length is required to reserve it,
+// 501 when ffmpeg lacks an encoder, 500 otherwise
+func handleJobError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errLengthRequired):
+		httperror.Log(ctx, err, http.StatusLengthRequired, "job rejected")
+		http.Error(w, err.Error(), http.StatusLengthRequired)
+
+	case errors.Is(err, ErrTmpBudget):
+		httperror.Log(ctx, err, http.StatusServiceUnavailable, "job rejected")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+	case errors.Is(err, ErrUnavailable):
+		httperror.Log(ctx, err, http.StatusNotImplemented, "job unavailable")
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+
+	default:
+		httperror.InternalServerError(ctx, w, err)
+	}
+}
@@ -0,0 +1,197 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTryReserve(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		limit    uint64
+		free     uint64
+		reserved uint64
+		written  int
+		size     uint64
+		wantOk   bool
+		wantErr  error
+	}{
+		"fits": {
+			0,
+			1000,
+			0,
+			0,
+			300,
+			true,
+			nil,
+		},
+		"never fits": {
+			0,
+			1000,
+			0,
+			0,
+			1200,
+			false,
+			ErrTmpBudget,
+		},
+		"above budget": {
+			500,
+			1000,
+			0,
+			0,
+			600,
+			false,
+			ErrTmpBudget,
+		},
+		"waits for budget": {
+			500,
+			1000,
+			300,
+			0,
+			300,
+			false,
+			nil,
+		},
+		"waits for unwritten reservation": {
+			0,
+			1000,
+			800,
+			0,
+			300,
+			false,
+			nil,
+		},
+		"written reservation is not counted twice": {
+			0,
+			300,
+			800,
+			700,
+			150,
+			true,
+			nil,
+		},
+		"written beyond reservation": {
+			0,
+			100,
+			800,
+			900,
+			100,
+			true,
+			nil,
+		},
+		"waits for the rest of the reservation": {
+			0,
+			400,
+			800,
+			600,
+			300,
+			false,
+			nil,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			workFolder := t.TempDir()
+
+			if testCase.written != 0 {
+				if err := os.WriteFile(filepath.Join(workFolder, "input"), make([]byte, testCase.written), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			budget := newTmpBudget(workFolder, workFolder, testCase.limit, 0, time.Second)
+			budget.free = func(string) (uint64, error) { return testCase.free, nil }
+			budget.reserved = testCase.reserved
+
+			ok, _, err := budget.tryReserve(testCase.size)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("tryReserve() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if ok != testCase.wantOk {
+				t.Errorf("tryReserve() = %t, want %t", ok, testCase.wantOk)
+			}
+		})
+	}
+}
+
+func TestReserveBody(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		bodySize      uint64
+		contentLength int64
+		wantErr       error
+		wantReserved  uint64
+		wantReadError bool
+	}{
+		"known length": {
+			0,
+			20,
+			nil,
+			20,
+			false,
+		},
+		"unknown length refused": {
+			0,
+			-1,
+			errLengthRequired,
+			0,
+			false,
+		},
+		"unknown length limited": {
+			10,
+			-1,
+			nil,
+			10,
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			workFolder := t.TempDir()
+
+			budget := newTmpBudget(workFolder, workFolder, 0, testCase.bodySize, time.Second)
+			budget.free = func(string) (uint64, error) { return 1000, nil }
+
+			service := Service{tmpBudget: budget}
+
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 20)))
+			request.ContentLength = testCase.contentLength
+
+			release, err := service.reserveBody(context.Background(), httptest.NewRecorder(), request)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("reserveBody() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if reserved := budget.current(); reserved != testCase.wantReserved {
+				t.Errorf("reserveBody() reserved %d, want %d", reserved, testCase.wantReserved)
+			}
+
+			if _, err = io.ReadAll(request.Body); (err != nil) != testCase.wantReadError {
+				t.Errorf("read body error = %v, want error %t", err, testCase.wantReadError)
+			}
+
+			release()
+
+			if reserved := budget.current(); reserved != 0 {
+				t.Errorf("release() kept %d bytes", reserved)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package vith
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/vith/pkg/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	cacheMetadataExtension = ".json"
+	pendingExtension       = ".pending"
+)
+
+var errCacheMiss = errors.New("cache miss")
+
+// cacheMetadata is stored alongside a cached thumbnail, for the headers of the response
+type cacheMetadata struct {
+	Placeholder *model.Placeholder `json:"placeholder,omitempty"`
+}
+
+type thumbnailCache interface {
+	get(ctx context.Context, key string) (io.ReadCloser, cacheMetadata, error)
+	set(ctx context.Context, key, thumbnailName string, metadata cacheMetadata) error
+}
+
+// cacheKey identifies a thumbnail by the hash of its input and the parameters of its generation, the watermark image by its content
+func (s Service) cacheKey(ctx context.Context, input hash.Hash, itemType model.ItemType, options thumbnailOptions) (string, error) {
+	overlayDigest, err := s.overlayDigest(ctx, options.overlay)
+	if err != nil {
+		return "", fmt.Errorf("hash watermark: %w", err)
+	}
+
+	return thumbnailCacheKey(input, itemType, options, overlayDigest), nil
+}
+
+// overlayDigest hashes the content of the watermark image, a watermark replaced at the same path has to change the key
+func (s Service) overlayDigest(ctx context.Context, overlay model.Overlay) (string, error) {
+	if len(overlay.Image) == 0 || !s.storage.Enabled() {
+		return "", nil
+	}
+
+	reader, err := s.storage.ReadFrom(ctx, overlay.Image)
+	if err != nil {
+		return "", fmt.Errorf("read watermark: %w", err)
+	}
+	defer closeWithLog(ctx, reader, "overlayDigest", overlay.Image)
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, reader); err != nil {
+		return "", fmt.Errorf("read watermark: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func thumbnailCacheKey(input hash.Hash, itemType model.ItemType, options thumbnailOptions, overlayDigest string) string {
+	hasher := sha256.New()
+	hasher.Write(input.Sum(nil))
+
+	fmt.Fprintf(hasher, "|%s|%s|%d|%d|%s|%s|%s|%g|%g", itemType, webpExtension, options.scale, options.page, overlayDigest, options.overlay.Text, options.overlay.Position, options.overlay.Opacity, options.overlay.Scale)
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// matchETag checks if the If-None-Match header of the request contains the entity tag
+func matchETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Service) recordCache(ctx context.Context, result string) {
+	if s.metrics.cache == nil {
+		return
+	}
+
+	s.metrics.cache.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
+type localCacheItem struct {
+	key  string
+	size int64
+}
+
+// localCache keeps thumbnails in a local folder, evicting the least recently used ones above the max size
+type localCache struct {
+	entries *list.List
+	index   map[string]*list.Element
+	folder  string
+	mutex   sync.Mutex
+	maxSize int64
+	size    int64
+}
+
+func newLocalCache(folder string, maxSize int64) (*localCache, error) {
+	if err := os.MkdirAll(folder, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache folder: %w", err)
+	}
+
+	dirEntries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("read cache folder: %w", err)
+	}
+
+	var infos []fs.FileInfo
+
+	for _, entry := range dirEntries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), pendingExtension) {
+			// left by a writer interrupted before its rename
+			_ = os.Remove(filepath.Join(folder, entry.Name()))
+			continue
+		}
+
+		if entry.IsDir() || filepath.Ext(entry.Name()) != webpExtension {
+			continue
+		}
+
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+
+	// most recently used first, the access time isn't reliable so the modification time is refreshed on every hit
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int {
+		return b.ModTime().Compare(a.ModTime())
+	})
+
+	cache := &localCache{
+		folder:  folder,
+		maxSize: maxSize,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+
+	for _, info := range infos {
+		key := strings.TrimSuffix(info.Name(), webpExtension)
+
+		cache.index[key] = cache.entries.PushBack(localCacheItem{key: key, size: info.Size()})
+		cache.size += info.Size()
+	}
+
+	cache.mutex.Lock()
+	cache.evict()
+	cache.mutex.Unlock()
+
+	return cache, nil
+}
+
+func (lc *localCache) name(key string) string {
+	return filepath.Join(lc.folder, key+webpExtension)
+}
+
+func (lc *localCache) get(ctx context.Context, key string) (io.ReadCloser, cacheMetadata, error) {
+	var metadata cacheMetadata
+
+	lc.mutex.Lock()
+	element, ok := lc.index[key]
+	if ok {
+		lc.entries.MoveToFront(element)
+	}
+	lc.mutex.Unlock()
+
+	if !ok {
+		return nil, metadata, errCacheMiss
+	}
+
+	reader, err := os.Open(lc.name(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, metadata, errCacheMiss
+		}
+
+		return nil, metadata, fmt.Errorf("open cached thumbnail: %w", err)
+	}
+
+	if err = os.Chtimes(lc.name(key), time.Time{}, time.Now()); err != nil {
+		slog.LogAttrs(ctx, slog.LevelWarn, "refresh cached thumbnail", slog.String("key", key), slog.Any("error", err))
+	}
+
+	if content, err := os.ReadFile(strings.TrimSuffix(lc.name(key), webpExtension) + cacheMetadataExtension); err == nil {
+		if err = json.Unmarshal(content, &metadata); err != nil {
+			slog.LogAttrs(ctx, slog.LevelWarn, "parse cached metadata", slog.String("key", key), slog.Any("error", err))
+		}
+	}
+
+	return reader, metadata, nil
+}
+
+func (lc *localCache) set(ctx context.Context, key, thumbnailName string, metadata cacheMetadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	input, err := os.Open(thumbnailName)
+	if err != nil {
+		return fmt.Errorf("open thumbnail: %w", err)
+	}
+	defer closeWithLog(ctx, input, "localCache.set", thumbnailName)
+
+	if _, err = lc.writeAtomically(strings.TrimSuffix(lc.name(key), webpExtension)+cacheMetadataExtension, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+
+	size, err := lc.writeAtomically(lc.name(key), input)
+	if err != nil {
+		return fmt.Errorf("write cached thumbnail: %w", err)
+	}
+
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if element, ok := lc.index[key]; ok {
+		lc.size -= element.Value.(localCacheItem).size
+		lc.entries.Remove(element)
+	}
+
+	lc.index[key] = lc.entries.PushFront(localCacheItem{key: key, size: size})
+	lc.size += size
+
+	lc.evict()
+
+	return nil
+}
+
+// evict removes the least recently used thumbnails until the cache fits its max size, the mutex has to be held
+func (lc *localCache) evict() {
+	for lc.size > lc.maxSize && lc.entries.Len() > 0 {
+		item := lc.entries.Remove(lc.entries.Back()).(localCacheItem)
+		delete(lc.index, item.key)
+		lc.size -= item.size
+
+		_ = os.Remove(lc.name(item.key))
+		_ = os.Remove(strings.TrimSuffix(lc.name(item.key), webpExtension) + cacheMetadataExtension)
+	}
+}
+
+// writeAtomically writes to a temporary file of the cache folder, unique to the writer, then renames it to the target,
+// so concurrent writers of the same key never interleave and readers never see a partial file
+func (lc *localCache) writeAtomically(target string, input io.Reader) (int64, error) {
+	output, err := os.CreateTemp(lc.folder, filepath.Base(target)+".*"+pendingExtension)
+	if err != nil {
+		return 0, fmt.Errorf("create: %w", err)
+	}
+
+	size, err := io.Copy(output, input)
+	if err = errors.Join(err, output.Close()); err != nil {
+		_ = os.Remove(output.Name())
+		return 0, fmt.Errorf("copy: %w", err)
+	}
+
+	if err = os.Rename(output.Name(), target); err != nil {
+		_ = os.Remove(output.Name())
+		return 0, fmt.Errorf("rename: %w", err)
+	}
+
+	return size, nil
+}
+
+// storageCache keeps thumbnails in a folder of the storage, its eviction is left to the lifecycle rules of the storage
+type storageCache struct {
+	storage absto.Storage
+	folder  string
+}
+
+func (sc storageCache) name(key string) string {
+	return path.Join(sc.folder, key[:2], key+webpExtension)
+}
+
+func (sc storageCache) get(ctx context.Context, key string) (io.ReadCloser, cacheMetadata, error) {
+	var metadata cacheMetadata
+
+	reader, err := sc.storage.ReadFrom(ctx, sc.name(key))
+	if err != nil {
+		if absto.IsNotExist(sc.storage.ConvertError(err)) {
+			return nil, metadata, errCacheMiss
+		}
+
+		return nil, metadata, fmt.Errorf("read cached thumbnail: %w", err)
+	}
+
+	if metadataReader, err := sc.storage.ReadFrom(ctx, strings.TrimSuffix(sc.name(key), webpExtension)+cacheMetadataExtension); err == nil {
+		if err = json.NewDecoder(metadataReader).Decode(&metadata); err != nil {
+			slog.LogAttrs(ctx, slog.LevelWarn, "parse cached metadata", slog.String("key", key), slog.Any("error", err))
+		}
+
+		closeWithLog(ctx, metadataReader, "storageCache.get", key)
+	}
+
+	return reader, metadata, nil
+}
+
+func (sc storageCache) set(ctx context.Context, key, thumbnailName string, metadata cacheMetadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	name := sc.name(key)
+
+	if err = sc.storage.Mkdir(ctx, path.Dir(name), absto.DirectoryPerm); err != nil {
+		return fmt.Errorf("create cache directory: %w", err)
+	}
+
+	if err = sc.storage.WriteTo(ctx, strings.TrimSuffix(name, webpExtension)+cacheMetadataExtension, bytes.NewReader(content), absto.WriteOpts{Size: int64(len(content))}); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+
+	input, err := os.Open(thumbnailName)
+	if err != nil {
+		return fmt.Errorf("open thumbnail: %w", err)
+	}
+	defer closeWithLog(ctx, input, "storageCache.set", thumbnailName)
+
+	info, err := input.Stat()
+	if err != nil {
+		return fmt.Errorf("stat thumbnail: %w", err)
+	}
+
+	if err = sc.storage.WriteTo(ctx, name, input, absto.WriteOpts{Size: info.Size()}); err != nil {
+		return fmt.Errorf("write cached thumbnail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package vith
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ViBiOh/absto/pkg/filesystem"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+func TestMatchETag(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		"empty": {
+			"",
+			`"abc"`,
+			false,
+		},
+		"exact": {
+			`"abc"`,
+			`"abc"`,
+			true,
+		},
+		"other": {
+			`"def"`,
+			`"abc"`,
+			false,
+		},
+		"list": {
+			`"def", "abc"`,
+			`"abc"`,
+			true,
+		},
+		"weak": {
+			`W/"abc"`,
+			`"abc"`,
+			true,
+		},
+		"wildcard": {
+			"*",
+			`"abc"`,
+			true,
+		},
+		"unquoted": {
+			"abc",
+			`"abc"`,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := matchETag(testCase.header, testCase.etag); got != testCase.want {
+				t.Errorf("matchETag() = %t, want %t", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	storage, err := filesystem.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := Service{storage: storage}
+	ctx := context.Background()
+
+	key := func(content string, overlay model.Overlay) string {
+		t.Helper()
+
+		if len(content) != 0 {
+			if err := os.WriteFile(filepath.Join(root, strings.TrimPrefix(overlay.Image, "/")), []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		input := sha256.New()
+		input.Write([]byte("input"))
+
+		output, err := service.cacheKey(ctx, input, model.TypeImage, newThumbnailOptions(defaultScale, 0, overlay))
+		if err != nil {
+			t.Fatalf("cacheKey() error = %s", err)
+		}
+
+		return output
+	}
+
+	// the cases share the watermark files so they run in order
+	none := key("", model.Overlay{})
+	first := key("first", model.Overlay{Image: "/watermark.png"})
+	replaced := key("second", model.Overlay{Image: "/watermark.png"})
+	copied := key("second", model.Overlay{Image: "/copy.png"})
+
+	if first == none {
+		t.Error("cacheKey() is the same with and without watermark")
+	}
+
+	if first == replaced {
+		t.Error("cacheKey() is the same for a watermark replaced at the same path")
+	}
+
+	if replaced != copied {
+		t.Error("cacheKey() differs for the same watermark at another path")
+	}
+
+	if _, err = service.cacheKey(ctx, sha256.New(), model.TypeImage, newThumbnailOptions(defaultScale, 0, model.Overlay{Image: "/missing.png"})); err == nil {
+		t.Error("cacheKey() error = nil for a missing watermark")
+	}
+}
+
+func TestLocalCacheSet(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(folder, "interrupted.webp.123.pending"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := newLocalCache(folder, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 8
+
+	thumbnails := make([]string, writers)
+
+	for i := range thumbnails {
+		thumbnails[i] = filepath.Join(t.TempDir(), "thumbnail.webp")
+
+		if err = os.WriteFile(thumbnails[i], []byte(strings.Repeat(fmt.Sprint(i), 4096)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for _, thumbnail := range thumbnails {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := cache.set(ctx, "key", thumbnail, cacheMetadata{}); err != nil {
+				t.Errorf("set() error = %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	content, err := os.ReadFile(cache.name("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) != 4096 || strings.Count(string(content), string(content[0])) != len(content) {
+		t.Errorf("cached thumbnail mixes the content of several writers")
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), pendingExtension) {
+			t.Errorf("pending file `%s` left in the cache folder", entry.Name())
+		}
+	}
+
+	if cache.size != 4096 {
+		t.Errorf("size = %d, want 4096", cache.size)
+	}
+}
@@ -0,0 +1,210 @@
+package vith
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const detectTimeout = 10 * time.Second
+
+// minFFmpegVersion is the oldest major version of ffmpeg with every filter and option used
+const minFFmpegVersion = 5
+
+// ErrUnavailable occurs when a job needs an encoder that the installed ffmpeg doesn't have
+var ErrUnavailable = errors.New("unavailable with the installed ffmpeg")
+
+// featureEncoders lists the ffmpeg encoders that each feature needs
+var featureEncoders = map[string][]string{
+	"thumbnail":    {"libwebp"},
+	"stream":       {"libx264", "aac"},
+	"stream_hdr":   {"libx265"},
+	"transcode":    {"libx264", "aac"},
+	"clip":         {"libx264", "aac"},
+	"preview_mp4":  {"libx264"},
+	"preview_webm": {"libvpx-vp9"},
+}
+
+// Capabilities describes the ffmpeg and ffprobe binaries found at startup, and the features they allow
+type Capabilities struct {
+	Features map[string]bool `json:"features"`
+	FFmpeg   string          `json:"ffmpeg,omitempty"`
+	FFprobe  string          `json:"ffprobe,omitempty"`
+	Encoders []string        `json:"encoders"`
+	HWAccels []string        `json:"hwaccels"`
+	Problems []string        `json:"problems,omitempty"`
+}
+
+// DetectCapabilities runs ffmpeg and ffprobe to find their version, encoders and hardware accelerations
+func DetectCapabilities(ctx context.Context) Capabilities {
+	ctx, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+
+	var capabilities Capabilities
+
+	if output, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-version").Output(); err != nil {
+		capabilities.Problems = append(capabilities.Problems, fmt.Sprintf("ffmpeg: %s", err))
+	} else {
+		capabilities.FFmpeg = parseVersion(output)
+
+		if major, ok := majorVersion(capabilities.FFmpeg); ok && major < minFFmpegVersion {
+			capabilities.Problems = append(capabilities.Problems, fmt.Sprintf("ffmpeg %s is older than %d", capabilities.FFmpeg, minFFmpegVersion))
+		}
+	}
+
+	if output, err := exec.CommandContext(ctx, "ffprobe", "-hide_banner", "-version").Output(); err != nil {
+		capabilities.Problems = append(capabilities.Problems, fmt.Sprintf("ffprobe: %s", err))
+	} else {
+		capabilities.FFprobe = parseVersion(output)
+	}
+
+	if len(capabilities.FFmpeg) != 0 {
+		if output, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders").Output(); err != nil {
+			capabilities.Problems = append(capabilities.Problems, fmt.Sprintf("ffmpeg encoders: %s", err))
+		} else {
+			capabilities.Encoders = parseEncoders(output)
+		}
+
+		if output, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-hwaccels").Output(); err != nil {
+			slog.LogAttrs(ctx, slog.LevelWarn, "detect ffmpeg hardware accelerations", slog.Any("error", err))
+		} else {
+			capabilities.HWAccels = parseHWAccels(output)
+		}
+	}
+
+	capabilities.Features = make(map[string]bool, len(featureEncoders))
+
+	for feature, encoders := range featureEncoders {
+		capabilities.Features[feature] = true
+
+		for _, encoder := range encoders {
+			if !slices.Contains(capabilities.Encoders, encoder) {
+				capabilities.Features[feature] = false
+
+				if len(capabilities.FFmpeg) != 0 {
+					slog.LogAttrs(ctx, slog.LevelWarn, "Feature unavailable, ffmpeg has no encoder for it", slog.String("feature", feature), slog.String("encoder", encoder))
+				}
+
+				break
+			}
+		}
+	}
+
+	if len(capabilities.FFmpeg) != 0 && !capabilities.Features["thumbnail"] {
+		capabilities.Problems = append(capabilities.Problems, "ffmpeg has no libwebp encoder")
+	}
+
+	for _, problem := range capabilities.Problems {
+		slog.LogAttrs(ctx, slog.LevelError, "ffmpeg capabilities", slog.String("problem", problem))
+	}
+
+	slog.LogAttrs(ctx, slog.LevelInfo, "ffmpeg capabilities", slog.String("ffmpeg", capabilities.FFmpeg), slog.String("ffprobe", capabilities.FFprobe), slog.Any("hwaccels", capabilities.HWAccels))
+
+	return capabilities
+}
+
+// Ping reports the problems found at startup, for the readiness of the service
+func (c Capabilities) Ping(_ context.Context) error {
+	if len(c.Problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(c.Problems, ", "))
+}
+
+func (c Capabilities) available(feature string) bool {
+	return c.Features[feature]
+}
+
+func (s Service) requireFeature(feature string) error {
+	if s.capabilities.available(feature) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s needs %s", ErrUnavailable, feature, strings.Join(featureEncoders[feature], ", "))
+}
+
+func (s Service) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.capabilities); err != nil {
+		slog.LogAttrs(r.Context(), slog.LevelError, "encode capabilities", slog.Any("error", err))
+	}
+}
+
+// parseVersion reads the version from the first line of the output, e.g. `ffmpeg version 6.1.1 Copyright...`
+func parseVersion(output []byte) string {
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || fields[1] != "version" {
+		return "unknown"
+	}
+
+	return fields[2]
+}
+
+// majorVersion parses the major of a release version, git builds (e.g. N-113000-g...) being considered recent enough
+func majorVersion(version string) (int, bool) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "n"), ".")
+
+	value, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// parseEncoders reads the names listed after the legend, e.g. ` V....D libwebp    libwebp WebP image (codec webp)`
+func parseEncoders(output []byte) []string {
+	var encoders []string
+	var listed bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if !listed {
+			listed = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+
+		if len(fields) >= 2 {
+			encoders = append(encoders, fields[1])
+		}
+	}
+
+	return encoders
+}
+
+// parseHWAccels reads the methods listed after the `Hardware acceleration methods:` line
+func parseHWAccels(output []byte) []string {
+	var hwaccels []string
+	var listed bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if !listed {
+			listed = line == "Hardware acceleration methods:"
+			continue
+		}
+
+		if len(line) != 0 {
+			hwaccels = append(hwaccels, line)
+		}
+	}
+
+	return hwaccels
+}
@@ -0,0 +1,222 @@
+package vith
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	ffmpegEncodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D a64multi             Multicolor charset for Commodore 64 (codec a64_multi)
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_vaapi           H.264/AVC (VAAPI) (codec h264)
+ V..... libx265              libx265 H.265 / HEVC (codec hevc)
+ V....D libvpx-vp9           libvpx VP9 (codec vp9)
+ V....D libwebp_anim         libwebp WebP image (codec webp)
+ V....D libwebp              libwebp WebP image (codec webp)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libopus              libopus Opus (codec opus)
+ S..... srt                  SubRip subtitle (codec subrip)
+`
+
+	ffmpegHWAccelsOutput = `Hardware acceleration methods:
+vdpau
+cuda
+vaapi
+qsv
+drm
+opencl
+vulkan
+
+`
+
+	ffmpegBannerHWAccelsOutput = `ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers
+  built with gcc 13.2.1 (Alpine 13.2.1_git20231014) 20231014
+  configuration: --prefix=/usr --enable-libwebp --enable-libx264 --enable-libx265
+  libavutil      58. 29.100 / 58. 29.100
+Hardware acceleration methods:
+vaapi
+
+`
+)
+
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		output string
+		want   string
+	}{
+		"release": {
+			"ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 13.2.1 (Alpine 13.2.1_git20231014) 20231014\n",
+			"6.1.1",
+		},
+		"tag": {
+			"ffmpeg version n7.0 Copyright (c) 2000-2024 the FFmpeg developers\n",
+			"n7.0",
+		},
+		"distribution": {
+			"ffmpeg version 5.1.4-0+deb12u1 Copyright (c) 2000-2023 the FFmpeg developers\n",
+			"5.1.4-0+deb12u1",
+		},
+		"git": {
+			"ffmpeg version N-113000-g1f0f7a3e14-20231127 Copyright (c) 2000-2023 the FFmpeg developers\n",
+			"N-113000-g1f0f7a3e14-20231127",
+		},
+		"ffprobe": {
+			"ffprobe version 4.4.2-0ubuntu0.22.04.1 Copyright (c) 2007-2021 the FFmpeg developers\n",
+			"4.4.2-0ubuntu0.22.04.1",
+		},
+		"empty": {
+			"",
+			"unknown",
+		},
+		"garbage": {
+			"Illegal instruction\n",
+			"unknown",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseVersion([]byte(testCase.output)); got != testCase.want {
+				t.Errorf("parseVersion() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMajorVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		version string
+		want    int
+		wantOk  bool
+	}{
+		"release": {
+			"6.1.1",
+			6,
+			true,
+		},
+		"tag": {
+			"n7.0",
+			7,
+			true,
+		},
+		"distribution": {
+			"5.1.4-0+deb12u1",
+			5,
+			true,
+		},
+		"old": {
+			"4.4.2-0ubuntu0.22.04.1",
+			4,
+			true,
+		},
+		"git": {
+			"N-113000-g1f0f7a3e14-20231127",
+			0,
+			false,
+		},
+		"unknown": {
+			"unknown",
+			0,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, gotOk := majorVersion(testCase.version)
+			if got != testCase.want || gotOk != testCase.wantOk {
+				t.Errorf("majorVersion() = (%d, %t), want (%d, %t)", got, gotOk, testCase.want, testCase.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseEncoders(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		output string
+		want   []string
+	}{
+		"ffmpeg": {
+			ffmpegEncodersOutput,
+			[]string{"a64multi", "libx264", "h264_vaapi", "libx265", "libvpx-vp9", "libwebp_anim", "libwebp", "aac", "libopus", "srt"},
+		},
+		"legend only": {
+			"Encoders:\n V..... = Video\n ------\n",
+			nil,
+		},
+		"no legend": {
+			" V....D libwebp              libwebp WebP image (codec webp)\n",
+			nil,
+		},
+		"empty": {
+			"",
+			nil,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseEncoders([]byte(testCase.output)); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("parseEncoders() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestParseHWAccels(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		output string
+		want   []string
+	}{
+		"ffmpeg": {
+			ffmpegHWAccelsOutput,
+			[]string{"vdpau", "cuda", "vaapi", "qsv", "drm", "opencl", "vulkan"},
+		},
+		"banner": {
+			ffmpegBannerHWAccelsOutput,
+			[]string{"vaapi"},
+		},
+		"none": {
+			"Hardware acceleration methods:\n\n",
+			nil,
+		},
+		"empty": {
+			"",
+			nil,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseHWAccels([]byte(testCase.output)); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("parseHWAccels() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
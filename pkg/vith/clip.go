@@ -0,0 +1,442 @@
+package vith
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	mp4Extension      = ".mp4"
+	keyframeTolerance = 0.05
+
+	// keyframeSearch bounds the seconds read after the start to find the next keyframe
+	keyframeSearch = 60
+)
+
+var (
+	// errOutputExists occurs when the clip would overwrite an existing item
+	errOutputExists = errors.New("output already exists")
+
+	// errNotSmartCutable occurs when the copied packets can't follow a re-encoded head
+	errNotSmartCutable = errors.New("source can't be smart cut")
+)
+
+type clipOptions struct {
+	start    float64
+	duration float64
+}
+
+func (s Service) HandleClip(w http.ResponseWriter, r *http.Request) {
+	if !s.storage.Enabled() {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	input := "/" + r.PathValue("input")
+
+	output := r.URL.Query().Get("output")
+	if filepath.Ext(output) != mp4Extension {
+		httperror.BadRequest(ctx, w, fmt.Errorf("output query param is mandatory and must be a `%s` file", mp4Extension))
+		s.increaseMetric(ctx, "http", "clip", model.TypeVideo.String(), "invalid")
+		return
+	}
+
+	options, err := parseClipOptions(r)
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "clip", model.TypeVideo.String(), "invalid")
+		return
+	}
+
+	if err := s.storageClip(ctx, input, output, options); err != nil {
+		if errors.Is(err, errOutputExists) {
+			httperror.Log(ctx, err, http.StatusConflict, "conflict")
+			w.WriteHeader(http.StatusConflict)
+			s.increaseMetric(ctx, "http", "clip", model.TypeVideo.String(), "conflict")
+			return
+		}
+
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "clip", model.TypeVideo.String(), "error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	s.increaseMetric(ctx, "http", "clip", model.TypeVideo.String(), "success")
+}
+
+func parseClipOptions(r *http.Request) (options clipOptions, err error) {
+	query := r.URL.Query()
+
+	if raw := query.Get("start"); len(raw) > 0 {
+		if options.start, err = strconv.ParseFloat(raw, 64); err != nil {
+			return options, fmt.Errorf("parse start: %w", err)
+		}
+	}
+
+	if options.start < 0 {
+		return options, errors.New("start must be positive")
+	}
+
+	rawEnd := query.Get("end")
+	rawDuration := query.Get("duration")
+
+	switch {
+	case len(rawEnd) > 0:
+		end, err := strconv.ParseFloat(rawEnd, 64)
+		if err != nil {
+			return options, fmt.Errorf("parse end: %w", err)
+		}
+
+		options.duration = end - options.start
+
+	case len(rawDuration) > 0:
+		if options.duration, err = strconv.ParseFloat(rawDuration, 64); err != nil {
+			return options, fmt.Errorf("parse duration: %w", err)
+		}
+
+	default:
+		return options, errors.New("end or duration query param is mandatory")
+	}
+
+	if options.duration <= 0 {
+		return options, errors.New("clip must have a positive duration")
+	}
+
+	return options, nil
+}
+
+func (s Service) storageClip(ctx context.Context, input, output string, options clipOptions) error {
+	if _, err := s.storage.Stat(ctx, output); err == nil {
+		return fmt.Errorf("%w: %s", errOutputExists, output)
+	} else if !absto.IsNotExist(err) {
+		return fmt.Errorf("stat output: %w", err)
+	}
+
+	if err := s.storage.Mkdir(ctx, path.Dir(output), absto.DirectoryPerm); err != nil {
+		return fmt.Errorf("create directory for output: %w", err)
+	}
+
+	inputName, finalizeInput, err := s.getInputName(ctx, input)
+	if err != nil {
+		return fmt.Errorf("get input name: %w", err)
+	}
+	defer finalizeInput()
+
+	outputName, finalizeOutput := s.getOutputName(ctx, output)
+
+	return errors.Join(s.videoClip(ctx, inputName, outputName, options), finalizeOutput())
+}
+
+// videoClip copies the packets when the start is on a keyframe. Otherwise, only the head up to the next keyframe is re-encoded and concatenated
+// with the packets copied from that keyframe, when the source allows it, or the whole clip is re-encoded.
+func (s Service) videoClip(ctx context.Context, inputName, outputName string, options clipOptions) error {
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_clip")
+	defer end(&err)
+
+	ctx, done := s.startJob(ctx, "clip", model.TypeVideo)
+	defer done()
+
+	keyframe, found, keyframeErr := s.nextKeyframe(ctx, inputName, options)
+	if keyframeErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "find keyframe", slog.String("input", inputName), slog.Any("error", keyframeErr))
+	}
+
+	switch clipModeAt(options, keyframe, found) {
+	case clipCopy:
+		// cutting on a keyframe, the packets can be copied without loss
+		err = s.runClip(ctx, inputName, outputName, options, "-codec", "copy", "-avoid_negative_ts", "make_zero")
+
+	case clipSmart:
+		if err = s.smartClip(ctx, inputName, outputName, options, keyframe); err == nil {
+			break
+		}
+
+		if !errors.Is(err, errNotSmartCutable) {
+			slog.LogAttrs(ctx, slog.LevelWarn, "smart clip, re-encoding the whole clip", slog.String("input", inputName), slog.Any("error", err))
+		}
+
+		err = s.encodeClip(ctx, inputName, outputName, options)
+
+	default:
+		err = s.encodeClip(ctx, inputName, outputName, options)
+	}
+
+	if err != nil {
+		cleanLocalFile(ctx, outputName)
+		return err
+	}
+
+	s.recordInputSize(ctx, inputName)
+	s.recordOutputSize(ctx, outputName)
+
+	return nil
+}
+
+type clipMode int
+
+const (
+	clipEncode clipMode = iota
+	clipCopy
+	clipSmart
+)
+
+// clipModeAt chooses how to cut given the first keyframe at or after the start: copy on a keyframe, smart cut when the keyframe is inside the clip
+func clipModeAt(options clipOptions, keyframe float64, found bool) clipMode {
+	switch {
+	case !found:
+		return clipEncode
+	case keyframe-options.start < keyframeTolerance:
+		return clipCopy
+	case keyframe < options.start+options.duration:
+		return clipSmart
+	default:
+		return clipEncode
+	}
+}
+
+// clipEncodeOptions re-encodes the whole clip
+var clipEncodeOptions = []string{"-codec:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p", "-codec:a", "aac", "-b:a", "128k"}
+
+func (s Service) encodeClip(ctx context.Context, inputName, outputName string, options clipOptions) error {
+	if err := s.requireFeature("clip"); err != nil {
+		return err
+	}
+
+	return s.runClip(ctx, inputName, outputName, options, clipEncodeOptions...)
+}
+
+// smartClip re-encodes the head of the clip, up to the keyframe, with the profile, level and audio layout of the source, and copies the packets after it.
+// Both parts are joined through MPEG-TS, that carries the parameter sets of each part in band, and muxed as `avc3` so players read them from the samples.
+func (s Service) smartClip(ctx context.Context, inputName, outputName string, options clipOptions, keyframe float64) error {
+	headOptions, ok := s.smartCutOptions(ctx, inputName)
+	if !ok {
+		return errNotSmartCutable
+	}
+
+	if err := s.requireFeature("clip"); err != nil {
+		return err
+	}
+
+	headName := outputName + "_head.ts"
+	defer cleanLocalFile(ctx, headName)
+
+	tailName := outputName + "_tail.ts"
+	defer cleanLocalFile(ctx, tailName)
+
+	listName := outputName + "_concat.txt"
+	defer cleanLocalFile(ctx, listName)
+
+	head := clipOptions{start: options.start, duration: keyframe - options.start}
+	tail := clipOptions{start: keyframe, duration: options.start + options.duration - keyframe}
+
+	if err := s.runClip(ctx, inputName, headName, head, append(headOptions, "-f", "mpegts")...); err != nil {
+		return fmt.Errorf("head: %w", err)
+	}
+
+	if err := s.runClip(ctx, inputName, tailName, tail, "-codec", "copy", "-avoid_negative_ts", "make_zero", "-bsf:v", "h264_mp4toannexb", "-f", "mpegts"); err != nil {
+		return fmt.Errorf("tail: %w", err)
+	}
+
+	if err := os.WriteFile(listName, []byte(concatList(headName, tailName)), absto.RegularFilePerm); err != nil {
+		return fmt.Errorf("write concat list: %w", err)
+	}
+
+	return s.runFFmpeg(ctx, "ffmpeg concat", "-f", "concat", "-safe", "0", "-i", listName, "-map", "0", "-codec", "copy", "-tag:v", "avc3", "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart", "-y", "-f", mp4Format, outputName)
+}
+
+// concatList writes the files for the ffmpeg concat demuxer, quoted for its parser
+func concatList(names ...string) string {
+	var output strings.Builder
+
+	for _, name := range names {
+		fmt.Fprintf(&output, "file '%s'\n", strings.ReplaceAll(name, "'", `'\''`))
+	}
+
+	return output.String()
+}
+
+func (s Service) runClip(ctx context.Context, inputName, outputName string, options clipOptions, codecOptions ...string) error {
+	ffmpegOpts := []string{"-ss", fmt.Sprintf("%.3f", options.start), "-i", inputName, "-t", fmt.Sprintf("%.3f", options.duration), "-map", "0:v:0", "-map", "0:a:0?", "-map_metadata", "-1"}
+	ffmpegOpts = append(ffmpegOpts, codecOptions...)
+
+	if !slices.Contains(codecOptions, "-f") {
+		ffmpegOpts = append(ffmpegOpts, "-movflags", "+faststart", "-f", mp4Format)
+	}
+
+	return s.runFFmpeg(ctx, "ffmpeg clip", append(ffmpegOpts, "-y", outputName)...)
+}
+
+func (s Service) runFFmpeg(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err := s.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("%s: %s: %w", name, buffer.String(), err)
+	}
+
+	return nil
+}
+
+// nextKeyframe finds the first keyframe at or after the start of the clip
+func (s Service) nextKeyframe(ctx context.Context, inputName string, options clipOptions) (float64, bool, error) {
+	if options.start == 0 {
+		return 0, true, nil
+	}
+
+	interval := fmt.Sprintf("%.3f%%+%.3f", math.Max(options.start-1, 0), 1+math.Min(options.duration, keyframeSearch))
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-skip_frame", "nokey", "-read_intervals", interval, "-show_entries", "frame=pts_time", "-of", "csv=p=0", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err := s.runCommand(ctx, cmd); err != nil {
+		return 0, false, fmt.Errorf("ffprobe keyframes: %w", err)
+	}
+
+	keyframe, found := keyframeAfter(buffer.Bytes(), options.start)
+
+	return keyframe, found, nil
+}
+
+// keyframeAfter reads the first keyframe timestamp, listed one per line, at or after the start
+func keyframeAfter(output []byte, start float64) (float64, bool) {
+	keyframe, found := 0.0, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		pts, err := strconv.ParseFloat(strings.Trim(scanner.Text(), ", "), 64)
+		if err != nil || pts <= start-keyframeTolerance {
+			continue
+		}
+
+		if !found || pts < keyframe {
+			keyframe, found = pts, true
+		}
+	}
+
+	return keyframe, found
+}
+
+// x264Profiles maps the H.264 profiles reported by ffprobe to the libx264 ones that encode the same parameter sets constraints
+var x264Profiles = map[string]string{
+	"Constrained Baseline": "baseline",
+	"Baseline":             "baseline",
+	"Main":                 "main",
+	"High":                 "high",
+}
+
+type clipStream struct {
+	Tags       map[string]string `json:"tags"`
+	CodecType  string            `json:"codec_type"`
+	CodecName  string            `json:"codec_name"`
+	PixFmt     string            `json:"pix_fmt"`
+	Profile    string            `json:"profile"`
+	SampleRate string            `json:"sample_rate"`
+	SideData   []probeSideData   `json:"side_data_list"`
+	Level      int               `json:"level"`
+	Channels   int               `json:"channels"`
+}
+
+type clipProbeOutput struct {
+	Streams []clipStream `json:"streams"`
+}
+
+// smartCutOptions probes the source and gives the encoding options of a head that the copied packets can follow
+func (s Service) smartCutOptions(ctx context.Context, inputName string) ([]string, bool) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "stream=codec_type,codec_name,pix_fmt,profile,level,sample_rate,channels:stream_tags=rotate:stream_side_data=rotation", "-of", "json", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err := s.runCommand(ctx, cmd); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "probe clip codecs", slog.String("input", inputName), slog.Any("error", err))
+		return nil, false
+	}
+
+	var output clipProbeOutput
+	if err := json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "parse clip codecs", slog.String("input", inputName), slog.Any("error", err))
+		return nil, false
+	}
+
+	return output.headOptions()
+}
+
+// headOptions encodes the head with the same video codec, pixel format, profile and level, and the same audio codec, profile, sample rate and channels.
+// Frames aren't scaled nor rotated, the resolution is the one of the source, so rotated sources are fully re-encoded.
+func (cpo clipProbeOutput) headOptions() ([]string, bool) {
+	var video, audio bool
+	var options []string
+
+	// only the first video and audio streams are clipped
+	for _, stream := range cpo.Streams {
+		switch {
+		case stream.CodecType == "video" && !video:
+			profile, ok := x264Profiles[stream.Profile]
+			if !ok || stream.CodecName != "h264" || stream.PixFmt != "yuv420p" || stream.Level <= 0 || stream.rotated() {
+				return nil, false
+			}
+
+			options = append(options, "-codec:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p", "-profile:v", profile, "-level:v", fmt.Sprintf("%d.%d", stream.Level/10, stream.Level%10))
+			video = true
+
+		case stream.CodecType == "audio" && !audio:
+			if stream.CodecName != "aac" || stream.Profile != "LC" || len(stream.SampleRate) == 0 || stream.Channels <= 0 {
+				return nil, false
+			}
+
+			options = append(options, "-codec:a", "aac", "-b:a", "128k", "-ar", stream.SampleRate, "-ac", strconv.Itoa(stream.Channels))
+			audio = true
+		}
+	}
+
+	return options, video
+}
+
+func (cs clipStream) rotated() bool {
+	for _, sideData := range cs.SideData {
+		if rotationToOrientation(sideData.Rotation) != 0 {
+			return true
+		}
+	}
+
+	rotate, err := strconv.ParseFloat(strings.TrimSpace(cs.Tags["rotate"]), 64)
+
+	return err == nil && rotationToOrientation(rotate) != 0
+}
@@ -0,0 +1,278 @@
+package vith
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/ViBiOh/absto/pkg/filesystem"
+)
+
+func TestKeyframeAfter(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		output   string
+		start    float64
+		keyframe float64
+		found    bool
+	}{
+		"empty": {
+			"",
+			10,
+			0,
+			false,
+		},
+		"on keyframe": {
+			"8.008000\n10.010000\n12.012000\n",
+			10,
+			10.01,
+			true,
+		},
+		"between keyframes": {
+			"8.008000,\n12.012000,\n16.016000,\n",
+			10,
+			12.012,
+			true,
+		},
+		"unordered": {
+			"16.016000\nN/A\n12.012000\n",
+			10,
+			12.012,
+			true,
+		},
+		"before start": {
+			"8.008000\n9.500000\n",
+			10,
+			0,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			keyframe, found := keyframeAfter([]byte(testCase.output), testCase.start)
+			if keyframe != testCase.keyframe || found != testCase.found {
+				t.Errorf("keyframeAfter() = (%g, %t), want (%g, %t)", keyframe, found, testCase.keyframe, testCase.found)
+			}
+		})
+	}
+}
+
+func TestClipModeAt(t *testing.T) {
+	t.Parallel()
+
+	options := clipOptions{start: 10, duration: 5}
+
+	cases := map[string]struct {
+		options  clipOptions
+		keyframe float64
+		found    bool
+		want     clipMode
+	}{
+		"no keyframe": {
+			options,
+			0,
+			false,
+			clipEncode,
+		},
+		"from the beginning": {
+			clipOptions{duration: 5},
+			0,
+			true,
+			clipCopy,
+		},
+		"on keyframe": {
+			options,
+			10.01,
+			true,
+			clipCopy,
+		},
+		"keyframe inside": {
+			options,
+			12.012,
+			true,
+			clipSmart,
+		},
+		"keyframe after end": {
+			options,
+			16.016,
+			true,
+			clipEncode,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := clipModeAt(testCase.options, testCase.keyframe, testCase.found); got != testCase.want {
+				t.Errorf("clipModeAt() = %d, want %d", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHeadOptions(t *testing.T) {
+	t.Parallel()
+
+	const (
+		h264High = `{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High","level":41}`
+		aacLC    = `{"codec_type":"audio","codec_name":"aac","profile":"LC","sample_rate":"48000","channels":2}`
+	)
+
+	video := []string{"-codec:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p", "-profile:v", "high", "-level:v", "4.1"}
+
+	cases := map[string]struct {
+		probe  string
+		want   []string
+		wantOk bool
+	}{
+		"h264 and aac": {
+			`{"streams":[` + h264High + `,` + aacLC + `]}`,
+			append(slices.Clone(video), "-codec:a", "aac", "-b:a", "128k", "-ar", "48000", "-ac", "2"),
+			true,
+		},
+		"muted h264": {
+			`{"streams":[` + h264High + `]}`,
+			video,
+			true,
+		},
+		"constrained baseline mono": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"Constrained Baseline","level":30},{"codec_type":"audio","codec_name":"aac","profile":"LC","sample_rate":"44100","channels":1}]}`,
+			[]string{"-codec:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p", "-profile:v", "baseline", "-level:v", "3.0", "-codec:a", "aac", "-b:a", "128k", "-ar", "44100", "-ac", "1"},
+			true,
+		},
+		"extra audio": {
+			`{"streams":[` + h264High + `,` + aacLC + `,{"codec_type":"audio","codec_name":"ac3"}]}`,
+			append(slices.Clone(video), "-codec:a", "aac", "-b:a", "128k", "-ar", "48000", "-ac", "2"),
+			true,
+		},
+		"zero rotation": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High","level":41,"tags":{"rotate":"0"},"side_data_list":[{"rotation":0}]}]}`,
+			video,
+			true,
+		},
+		"hevc": {
+			`{"streams":[{"codec_type":"video","codec_name":"hevc","pix_fmt":"yuv420p","profile":"Main","level":120},` + aacLC + `]}`,
+			nil,
+			false,
+		},
+		"10 bits": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p10le","profile":"High 10","level":51}]}`,
+			nil,
+			false,
+		},
+		"high 4:2:2": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High 4:2:2","level":41}]}`,
+			nil,
+			false,
+		},
+		"unknown level": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High","level":-99}]}`,
+			nil,
+			false,
+		},
+		"rotated side data": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High","level":41,"side_data_list":[{"rotation":-90}]}]}`,
+			nil,
+			false,
+		},
+		"rotate tag": {
+			`{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p","profile":"High","level":41,"tags":{"rotate":"180"}}]}`,
+			nil,
+			false,
+		},
+		"he-aac": {
+			`{"streams":[` + h264High + `,{"codec_type":"audio","codec_name":"aac","profile":"HE-AAC","sample_rate":"48000","channels":2}]}`,
+			nil,
+			false,
+		},
+		"opus": {
+			`{"streams":[` + h264High + `,{"codec_type":"audio","codec_name":"opus","sample_rate":"48000","channels":2}]}`,
+			nil,
+			false,
+		},
+		"audio only": {
+			`{"streams":[` + aacLC + `]}`,
+			nil,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			var probe clipProbeOutput
+			if err := json.Unmarshal([]byte(testCase.probe), &probe); err != nil {
+				t.Fatal(err)
+			}
+
+			got, gotOk := probe.headOptions()
+
+			if gotOk != testCase.wantOk {
+				t.Fatalf("headOptions() ok = %t, want %t", gotOk, testCase.wantOk)
+			}
+
+			if gotOk && !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("headOptions() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSmartClipNotCutable(t *testing.T) {
+	t.Parallel()
+
+	// the source can't be probed, so the caller must fall back to a full re-encode
+	err := Service{}.smartClip(context.Background(), filepath.Join(t.TempDir(), "missing.mp4"), filepath.Join(t.TempDir(), "clip.mp4"), clipOptions{start: 10, duration: 5}, 12)
+
+	if !errors.Is(err, errNotSmartCutable) {
+		t.Errorf("smartClip() = %v, want %v", err, errNotSmartCutable)
+	}
+}
+
+func TestConcatList(t *testing.T) {
+	t.Parallel()
+
+	want := "file '/tmp/clip_head.ts'\nfile '/tmp/it'\\''s_tail.ts'\n"
+
+	if got := concatList("/tmp/clip_head.ts", "/tmp/it's_tail.ts"); got != want {
+		t.Errorf("concatList() = `%s`, want `%s`", got, want)
+	}
+}
+
+func TestHandleClipConflict(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, "clip.mp4"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := filesystem.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/_/clip/video.mp4?output=/clip.mp4&start=10&duration=5", nil)
+	request.SetPathValue("input", "video.mp4")
+
+	Service{storage: storage}.HandleClip(writer, request)
+
+	if writer.Code != http.StatusConflict {
+		t.Errorf("HandleClip() = %d, want %d", writer.Code, http.StatusConflict)
+	}
+}
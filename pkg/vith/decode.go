@@ -0,0 +1,195 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+type imageFormat int
+
+const (
+	formatUnknown imageFormat = iota
+	formatRaw
+	formatHeif
+)
+
+const sniffLength = 32
+
+var (
+	heifBrands = [][]byte{[]byte("heic"), []byte("heix"), []byte("hevc"), []byte("hevx"), []byte("heim"), []byte("heis"), []byte("mif1"), []byte("msf1")}
+	rawBrands  = [][]byte{[]byte("crx ")}
+
+	rawSignatures = [][]byte{
+		[]byte("II*\x00"),              // TIFF based: CR2, NEF, ARW, DNG, PEF...
+		[]byte("MM\x00*"),              // TIFF based, big endian
+		[]byte("IIRO"),                 // ORF
+		[]byte("IIU\x00"),              // RW2
+		[]byte("FUJIFILMCCD-RAW"),      // RAF
+		[]byte("\x00MRM"),              // MRW
+		[]byte("FOVb"),                 // X3F
+		[]byte("ARRI\x12\x34\x56\x78"), // ARI
+	}
+)
+
+func (s Service) imageThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) error {
+	if s.isNativeImage(inputName) {
+		err := s.nativeImageThumbnail(ctx, inputName, outputName, options)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, errNativeUnsupported) {
+			slog.LogAttrs(ctx, slog.LevelInfo, "native pipeline failed to thumbnail image, trying ffmpeg", slog.String("input", inputName), slog.Any("error", err))
+		}
+	}
+
+	err := s.ffmpegImageThumbnail(ctx, inputName, outputName, options)
+	if err == nil {
+		return nil
+	}
+
+	format, sniffErr := sniffImageFormat(ctx, inputName)
+	if sniffErr != nil {
+		return errors.Join(err, sniffErr)
+	}
+
+	var decoder func(context.Context, string, string) error
+
+	switch format {
+	case formatRaw:
+		decoder = s.extractRawPreview
+	case formatHeif:
+		decoder = s.decodeHeif
+	default:
+		return err
+	}
+
+	slog.LogAttrs(ctx, slog.LevelInfo, "ffmpeg failed to decode image, trying fallback decoder", slog.String("input", inputName), slog.Any("error", err))
+
+	decodedName := outputName + "_decoded.jpg"
+	defer cleanLocalFile(ctx, decodedName)
+
+	if decodeErr := decoder(ctx, inputName, decodedName); decodeErr != nil {
+		return errors.Join(err, decodeErr)
+	}
+
+	return s.ffmpegImageThumbnail(ctx, decodedName, outputName, options)
+}
+
+func sniffImageFormat(ctx context.Context, inputName string) (imageFormat, error) {
+	reader, err := os.OpenFile(inputName, os.O_RDONLY, absto.RegularFilePerm)
+	if err != nil {
+		return formatUnknown, fmt.Errorf("open file: %w", err)
+	}
+
+	defer closeWithLog(ctx, reader, "sniffImageFormat", inputName)
+
+	header := make([]byte, sniffLength)
+
+	length, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return formatUnknown, fmt.Errorf("read header: %w", err)
+	}
+
+	return detectImageFormat(header[:length]), nil
+}
+
+func detectImageFormat(header []byte) imageFormat {
+	if len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")) {
+		brand := header[8:12]
+
+		for _, heifBrand := range heifBrands {
+			if bytes.Equal(brand, heifBrand) {
+				return formatHeif
+			}
+		}
+
+		for _, rawBrand := range rawBrands {
+			if bytes.Equal(brand, rawBrand) {
+				return formatRaw
+			}
+		}
+
+		return formatUnknown
+	}
+
+	for _, signature := range rawSignatures {
+		if bytes.HasPrefix(header, signature) {
+			return formatRaw
+		}
+	}
+
+	return formatUnknown
+}
+
+func (s Service) extractRawPreview(ctx context.Context, inputName, outputName string) (err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "exiftool_preview")
+	defer end(&err)
+
+	for _, tag := range []string{"-JpgFromRaw", "-PreviewImage"} {
+		if err = s.runToFile(ctx, outputName, "exiftool", "-b", tag, inputName); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("extract raw preview: %w", err)
+}
+
+func (s Service) decodeHeif(ctx context.Context, inputName, outputName string) (err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "heif_convert")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "heif-convert", "-q", "95", inputName, outputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return fmt.Errorf("heif-convert: %s: %w", buffer.String(), err)
+	}
+
+	return nil
+}
+
+func (s Service) runToFile(ctx context.Context, outputName, name string, args ...string) error {
+	writer, err := os.OpenFile(outputName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, absto.RegularFilePerm)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = writer
+	cmd.Stderr = buffer
+
+	err = s.runCommand(ctx, cmd)
+	closeWithLog(ctx, writer, "runToFile", outputName)
+
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", name, buffer.String(), err)
+	}
+
+	if info, statErr := os.Stat(outputName); statErr != nil || info.Size() == 0 {
+		return fmt.Errorf("%s: empty output", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package vith
+
+import (
+	"testing"
+)
+
+func TestDetectImageFormat(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		header string
+		want   imageFormat
+	}{
+		"heic": {
+			"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic",
+			formatHeif,
+		},
+		"heif image sequence": {
+			"\x00\x00\x00\x1cftypmsf1\x00\x00\x00\x00msf1hevc",
+			formatHeif,
+		},
+		"heif": {
+			"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1heic",
+			formatHeif,
+		},
+		"cr3": {
+			"\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom",
+			formatRaw,
+		},
+		"avif": {
+			"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1",
+			formatUnknown,
+		},
+		"mp4": {
+			"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2",
+			formatUnknown,
+		},
+		"cr2": {
+			"II*\x00\x10\x00\x00\x00CR\x02\x00",
+			formatRaw,
+		},
+		"nef big endian": {
+			"MM\x00*\x00\x00\x00\x08",
+			formatRaw,
+		},
+		"orf": {
+			"IIRO\x08\x00\x00\x00",
+			formatRaw,
+		},
+		"rw2": {
+			"IIU\x00\x18\x00\x00\x00",
+			formatRaw,
+		},
+		"raf": {
+			"FUJIFILMCCD-RAW 0201FF383501",
+			formatRaw,
+		},
+		"mrw": {
+			"\x00MRM\x00\x00\x00\x00",
+			formatRaw,
+		},
+		"x3f": {
+			"FOVb\x00\x00\x00\x00",
+			formatRaw,
+		},
+		"jpeg": {
+			"\xFF\xD8\xFF\xE0\x00\x10JFIF\x00",
+			formatUnknown,
+		},
+		"truncated ftyp": {
+			"\x00\x00\x00\x18ftyphe",
+			formatUnknown,
+		},
+		"truncated signature": {
+			"II",
+			formatUnknown,
+		},
+		"empty": {
+			"",
+			formatUnknown,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := detectImageFormat([]byte(testCase.header)); got != testCase.want {
+				t.Errorf("detectImageFormat() = %d, want %d", got, testCase.want)
+			}
+		})
+	}
+}
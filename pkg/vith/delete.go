@@ -22,8 +22,8 @@ func (s Service) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if itemType != model.TypeVideo {
-		httperror.BadRequest(ctx, w, errors.New("deletion is possible for video type only"))
+	if !itemType.IsStreamable() {
+		httperror.BadRequest(ctx, w, errors.New("deletion is possible for video or audio type only"))
 		return
 	}
 
@@ -32,8 +32,14 @@ func (s Service) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.cleanStream(ctx, r.URL.Path, s.storage.RemoveAll, s.listFiles, `.*\.ts`); err != nil {
+	if err := s.cleanStream(ctx, r.URL.Path, s.storage.RemoveAll, s.listFiles, streamFilesSuffix); err != nil {
 		httperror.InternalServerError(ctx, w, err)
+		return
+	}
+
+	if err := s.cleanStreamKeys(ctx, r.URL.Path); err != nil {
+		httperror.InternalServerError(ctx, w, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
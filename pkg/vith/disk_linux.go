@@ -0,0 +1,13 @@
+package vith
+
+import "syscall"
+
+// freeSpace returns the space available to unprivileged users in the folder's filesystem, in bytes
+func freeSpace(folder string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(folder, &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package vith
+
+// freeSpace is only available on Linux, zero means unknown
+func freeSpace(_ string) (uint64, error) {
+	return 0, nil
+}
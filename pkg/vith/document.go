@@ -0,0 +1,86 @@
+package vith
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+const pngExtension = ".png"
+
+func (s Service) documentThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) error {
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "pdftoppm_thumbnail")
+	defer end(&err)
+
+	page := strconv.FormatUint(options.page, 10)
+	pageName := outputName + "_page"
+
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-f", page, "-l", page, "-singlefile", "-png", "-scale-to", strconv.FormatUint(options.scale*2, 10), inputName, pageName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("pdftoppm: %s: %w", buffer.String(), err)
+	}
+
+	pageName += pngExtension
+	defer cleanLocalFile(ctx, pageName)
+
+	err = s.imageThumbnail(ctx, pageName, outputName, options)
+
+	return err
+}
+
+func (s Service) getDocumentPages(ctx context.Context, inputName string) (pages uint64, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "pdfinfo")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "pdfinfo", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return 0, fmt.Errorf("pdfinfo: %s: %w", buffer.String(), err)
+	}
+
+	return parsePdfinfoOutput(buffer.String())
+}
+
+func parsePdfinfoOutput(raw string) (uint64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found || key != "Pages" {
+			continue
+		}
+
+		pages, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse pages `%s`: %w", value, err)
+		}
+
+		return pages, nil
+	}
+
+	return 0, errors.New("no pages found in pdfinfo output")
+}
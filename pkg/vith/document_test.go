@@ -0,0 +1,75 @@
+package vith
+
+import (
+	"testing"
+)
+
+const pdfinfoOutput = `Title:          Pages: 3 of the report
+Author:         Jane Doe
+Creator:        Writer
+Producer:       LibreOffice 7.3
+CreationDate:   Mon Jan 15 10:00:00 2024 CET
+Tagged:         no
+UserProperties: no
+Suspects:       no
+Form:           none
+JavaScript:     no
+Pages:          12
+Encrypted:      no
+Page size:      595.276 x 841.89 pts (A4)
+Page rot:       0
+File size:      123456 bytes
+Optimized:      no
+PDF version:    1.7
+`
+
+func TestParsePdfinfoOutput(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		raw     string
+		want    uint64
+		wantErr bool
+	}{
+		"pdfinfo": {
+			pdfinfoOutput,
+			12,
+			false,
+		},
+		"crlf": {
+			"Producer:       Ghostscript\r\nPages:          1\r\nEncrypted:      no\r\n",
+			1,
+			false,
+		},
+		"no pages": {
+			"Producer:       Ghostscript\nEncrypted:      no\n",
+			0,
+			true,
+		},
+		"invalid pages": {
+			"Pages:          many\n",
+			0,
+			true,
+		},
+		"empty": {
+			"",
+			0,
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parsePdfinfoOutput(testCase.raw)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("parsePdfinfoOutput() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			if got != testCase.want {
+				t.Errorf("parsePdfinfoOutput() = %d, want %d", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package vith
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+)
+
+const (
+	keySize      = 16
+	keyExtension = ".key"
+
+	defaultKeyURI = "{key}"
+
+	rotationCheckInterval = time.Second
+
+	// keysSuffix matches every key of a stream, renditions included, when appended to its name without extension
+	keysSuffix = `(_hdr|_audio[0-9]+)?_key[0-9]+\.key$`
+)
+
+// errKeyLocation occurs when keys would be written next to the segments and served with them
+var errKeyLocation = errors.New("stream encryption needs a streamKeyFolder or a streamKeyURI other than the segments location")
+
+type streamEncryption struct {
+	stop     chan struct{}
+	done     chan struct{}
+	rawName  string
+	infoName string
+	keyURI   string
+	index    int
+}
+
+// prepareEncryption generates the first key of the stream and its ffmpeg key info file, nil if encryption is disabled
+func (s Service) prepareEncryption(ctx context.Context, outputName string) (*streamEncryption, error) {
+	if !s.streamEncryption {
+		return nil, nil
+	}
+
+	if !s.hasKeyLocation() {
+		return nil, errKeyLocation
+	}
+
+	encryption := &streamEncryption{
+		rawName:  strings.TrimSuffix(outputName, hlsExtension),
+		infoName: s.getLocalFilename("keyinfo_" + outputName),
+		keyURI:   s.keyURI,
+	}
+
+	if err := encryption.rotate(); err != nil {
+		return nil, err
+	}
+
+	if s.keyRotation > 0 {
+		encryption.stop = make(chan struct{})
+		encryption.done = make(chan struct{})
+
+		go encryption.watch(ctx, s.keyRotation)
+	}
+
+	return encryption, nil
+}
+
+func (se *streamEncryption) options() []string {
+	if se == nil {
+		return nil
+	}
+
+	return []string{"-hls_key_info_file", se.infoName}
+}
+
+func (se *streamEncryption) hlsFlags() string {
+	if se != nil && se.stop != nil {
+		return "independent_segments+periodic_rekey"
+	}
+
+	return "independent_segments"
+}
+
+func (se *streamEncryption) close(ctx context.Context) {
+	if se == nil {
+		return
+	}
+
+	if se.stop != nil {
+		close(se.stop)
+		<-se.done
+	}
+
+	cleanLocalFile(ctx, se.infoName)
+}
+
+// rotate generates a new key and points the key info file to it, ffmpeg reads it again on each segment with the `periodic_rekey` flag
+func (se *streamEncryption) rotate() error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+
+	keyName := fmt.Sprintf("%s_key%d%s", se.rawName, se.index, keyExtension)
+	if err := os.WriteFile(keyName, key, absto.RegularFilePerm); err != nil {
+		return fmt.Errorf("write key: %w", err)
+	}
+
+	keyInfo := fmt.Sprintf("%s\n%s\n", strings.ReplaceAll(se.keyURI, "{key}", filepath.Base(keyName)), keyName)
+
+	tmpInfoName := se.infoName + ".tmp"
+	if err := os.WriteFile(tmpInfoName, []byte(keyInfo), absto.RegularFilePerm); err != nil {
+		return fmt.Errorf("write key info: %w", err)
+	}
+
+	if err := os.Rename(tmpInfoName, se.infoName); err != nil {
+		return fmt.Errorf("replace key info: %w", err)
+	}
+
+	se.index++
+
+	return nil
+}
+
+// watch rotates the key every `rotation` segments written by ffmpeg
+func (se *streamEncryption) watch(ctx context.Context, rotation uint64) {
+	defer close(se.done)
+
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-se.stop:
+			return
+		case <-ticker.C:
+		}
+
+		segments, err := listLocalFiles(se.rawName, `[0-9]+\.ts$`)
+		if err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "list segments for key rotation", slog.String("name", se.rawName), slog.Any("error", err))
+			continue
+		}
+
+		if uint64(len(segments)) < uint64(se.index)*rotation {
+			continue
+		}
+
+		if err = se.rotate(); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "rotate key", slog.String("name", se.rawName), slog.Any("error", err))
+		}
+	}
+}
+
+// moveStreamKeys moves the keys of the stream from its directory to the key folder, keeping them out of the served files
+func (s Service) moveStreamKeys(ctx context.Context, name string) error {
+	if len(s.keyFolder) == 0 {
+		return nil
+	}
+
+	rawName := strings.TrimSuffix(name, hlsExtension)
+
+	keys, err := s.listFiles(ctx, rawName, keysSuffix)
+	if err != nil {
+		return fmt.Errorf("list keys for `%s`: %w", rawName, err)
+	}
+
+	if len(keys) > 0 {
+		if err = s.storage.Mkdir(ctx, s.keyName(path.Dir(name)), absto.DirectoryPerm); err != nil {
+			return fmt.Errorf("create key directory: %w", err)
+		}
+	}
+
+	for _, key := range keys {
+		if err = s.storage.Rename(ctx, key, s.keyName(key)); err != nil {
+			return fmt.Errorf("move key `%s`: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (s Service) cleanStreamKeys(ctx context.Context, name string) error {
+	if len(s.keyFolder) == 0 {
+		return nil
+	}
+
+	rawName := strings.TrimSuffix(s.keyName(name), hlsExtension)
+
+	keys, err := s.listFiles(ctx, rawName, keysSuffix)
+	if err != nil {
+		return fmt.Errorf("list keys for `%s`: %w", rawName, err)
+	}
+
+	for _, key := range keys {
+		if err = s.storage.RemoveAll(ctx, key); err != nil {
+			return fmt.Errorf("remove key `%s`: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (s Service) renameStreamKeys(ctx context.Context, source, destination string) error {
+	if len(s.keyFolder) == 0 {
+		return nil
+	}
+
+	rawSourceName := strings.TrimSuffix(s.keyName(source), hlsExtension)
+	rawDestinationName := strings.TrimSuffix(s.keyName(destination), hlsExtension)
+
+	keys, err := s.listFiles(ctx, rawSourceName, keysSuffix)
+	if err != nil {
+		return fmt.Errorf("list keys for `%s`: %w", rawSourceName, err)
+	}
+
+	if len(keys) > 0 {
+		if err = s.storage.Mkdir(ctx, path.Dir(rawDestinationName), absto.DirectoryPerm); err != nil {
+			return fmt.Errorf("create key directory: %w", err)
+		}
+	}
+
+	for _, key := range keys {
+		newName := rawDestinationName + strings.TrimPrefix(key, rawSourceName)
+		if err = s.storage.Rename(ctx, key, newName); err != nil {
+			return fmt.Errorf("rename `%s` to `%s`: %w", key, newName, err)
+		}
+	}
+
+	return nil
+}
+
+// hasKeyLocation checks that keys are either moved out of the stream directory or referenced elsewhere than next to the segments
+func (s Service) hasKeyLocation() bool {
+	return len(s.keyFolder) != 0 || s.keyURI != defaultKeyURI
+}
+
+// keyName returns the storage name of a key, mirroring the stream path in the key folder if any
+func (s Service) keyName(name string) string {
+	if len(s.keyFolder) == 0 {
+		return name
+	}
+
+	return path.Join(s.keyFolder, name)
+}
@@ -0,0 +1,98 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrepareEncryption(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		keyFolder string
+		keyURI    string
+		rotation  uint64
+		wantErr   error
+	}{
+		"next to segments": {
+			"",
+			defaultKeyURI,
+			0,
+			errKeyLocation,
+		},
+		"key folder": {
+			"/keys",
+			defaultKeyURI,
+			0,
+			nil,
+		},
+		"key uri": {
+			"",
+			"https://keys.example.com/{key}",
+			0,
+			nil,
+		},
+		"rotation": {
+			"/keys",
+			defaultKeyURI,
+			2,
+			nil,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			directory := t.TempDir()
+			service := Service{tmpFolder: directory, streamEncryption: true, keyFolder: testCase.keyFolder, keyURI: testCase.keyURI, keyRotation: testCase.rotation}
+
+			encryption, err := service.prepareEncryption(context.Background(), filepath.Join(directory, "video.m3u8"))
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("prepareEncryption() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if _, err = os.Stat(filepath.Join(directory, "video_key0.key")); err != nil {
+				t.Errorf("prepareEncryption() wrote no key: %s", err)
+			}
+
+			// close waits for the rotation watcher, nothing is written after it
+			encryption.close(context.Background())
+
+			if _, err = os.Stat(encryption.infoName); !os.IsNotExist(err) {
+				t.Errorf("close() kept key info file: %v", err)
+			}
+		})
+	}
+}
+
+func TestListLocalKeys(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+
+	for _, name := range []string{"clip (1).m3u8", "clip (1)_key0.key", "clip (1)_hdr_key1.key", "clip 1_key0.key", "clip (1)0.ts"} {
+		if err := os.WriteFile(filepath.Join(directory, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := listLocalFiles(filepath.Join(directory, "clip (1)"), keysSuffix)
+	if err != nil {
+		t.Fatalf("listLocalFiles() error = %s", err)
+	}
+
+	want := []string{filepath.Join(directory, "clip (1)_hdr_key1.key"), filepath.Join(directory, "clip (1)_key0.key")}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listLocalFiles() = %v, want %v", got, want)
+	}
+}
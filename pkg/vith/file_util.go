@@ -5,8 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	absto "github.com/ViBiOh/absto/pkg/model"
@@ -35,36 +38,38 @@ func (s Service) writeFile(ctx context.Context, name string, content []byte) err
 	return nil
 }
 
-func (s Service) listFiles(ctx context.Context, pattern string) ([]string, error) {
-	var items []string
+// listFiles lists the files of the storage directory of the raw name that are named after it, followed by the suffix
+func (s Service) listFiles(ctx context.Context, rawName, suffix string) ([]string, error) {
+	directory := strings.TrimPrefix(path.Dir(rawName), "/")
 
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("compile regular expression: %w", err)
-	}
+	var items []string
 
-	return items, s.storage.Walk(ctx, path.Dir(pattern), func(item absto.Item) error {
-		if re.MatchString(item.Pathname) {
+	if err := s.storage.Walk(ctx, path.Dir(rawName), func(item absto.Item) error {
+		if !item.IsDir() && strings.TrimPrefix(path.Dir(item.Pathname), "/") == directory {
 			items = append(items, item.Pathname)
 		}
 
 		return nil
-	})
+	}); err != nil {
+		return nil, err
+	}
+
+	return matchStreamFiles(rawName, suffix, items)
 }
 
-func (s Service) cleanStream(ctx context.Context, name string, remove func(context.Context, string) error, list func(context.Context, string) ([]string, error), suffix string) error {
+func (s Service) cleanStream(ctx context.Context, name string, remove func(context.Context, string) error, list func(context.Context, string, string) ([]string, error), suffix string) error {
 	if err := remove(ctx, name); err != nil {
 		return fmt.Errorf("remove `%s`: %w", name, err)
 	}
 
 	rawName := strings.TrimSuffix(name, hlsExtension)
 
-	segments, err := list(ctx, rawName+suffix)
+	files, err := list(ctx, rawName, suffix)
 	if err != nil {
-		return fmt.Errorf("list hls segments for `%s`: %w", rawName, err)
+		return fmt.Errorf("list hls files for `%s`: %w", rawName, err)
 	}
 
-	for _, file := range segments {
+	for _, file := range files {
 		if err := remove(ctx, file); err != nil {
 			return fmt.Errorf("remove `%s`: %w", file, err)
 		}
@@ -72,3 +77,56 @@ func (s Service) cleanStream(ctx context.Context, name string, remove func(conte
 
 	return nil
 }
+
+// listLocalFiles lists the files of the local directory of the raw name that are named after it, followed by the suffix
+func listLocalFiles(rawName, suffix string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(rawName))
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var items []string
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			items = append(items, filepath.Join(filepath.Dir(rawName), entry.Name()))
+		}
+	}
+
+	return matchStreamFiles(rawName, suffix, items)
+}
+
+// matchStreamFiles keeps the names whose base is the base of the raw name followed by the suffix.
+// A stream whose name starts with the raw name (e.g. `video2` for `video`) has files matching it too, those are left to their own stream.
+func matchStreamFiles(rawName, suffix string, names []string) ([]string, error) {
+	base := path.Base(rawName)
+
+	re, err := regexp.Compile("^" + regexp.QuoteMeta(base) + suffix)
+	if err != nil {
+		return nil, fmt.Errorf("compile regular expression: %w", err)
+	}
+
+	streamRe := regexp.MustCompile("^" + regexp.QuoteMeta(base) + streamFilesSuffix)
+
+	var others []*regexp.Regexp
+
+	for _, name := range names {
+		name = path.Base(name)
+
+		if other := strings.TrimSuffix(name, hlsExtension); other != name && other != base && strings.HasPrefix(other, base) && !streamRe.MatchString(name) {
+			others = append(others, regexp.MustCompile("^"+regexp.QuoteMeta(other)+streamFilesSuffix))
+		}
+	}
+
+	var items []string
+
+	for _, name := range names {
+		if !re.MatchString(path.Base(name)) || slices.ContainsFunc(others, func(other *regexp.Regexp) bool { return other.MatchString(path.Base(name)) }) {
+			continue
+		}
+
+		items = append(items, name)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,94 @@
+package vith
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchStreamFiles(t *testing.T) {
+	t.Parallel()
+
+	files := []string{
+		"/videos/video.m3u8",
+		"/videos/video0.ts",
+		"/videos/video12.ts",
+		"/videos/video_hdr.m3u8",
+		"/videos/video_hdr0.ts",
+		"/videos/video_master.m3u8",
+		"/videos/video_sub0.m3u8",
+		"/videos/video_sub0.vtt",
+		"/videos/video_audio1.m3u8",
+		"/videos/video_audio10.ts",
+		"/videos/video_key0.key",
+		"/videos/video.mp4",
+		"/videos/myvideo.m3u8",
+		"/videos/myvideo0.ts",
+		"/videos/video2.m3u8",
+		"/videos/video20.ts",
+	}
+
+	cases := map[string]struct {
+		rawName string
+		suffix  string
+		names   []string
+		want    []string
+	}{
+		"stream": {
+			"/videos/video",
+			streamFilesSuffix,
+			files,
+			[]string{
+				"/videos/video.m3u8",
+				"/videos/video0.ts",
+				"/videos/video12.ts",
+				"/videos/video_hdr.m3u8",
+				"/videos/video_hdr0.ts",
+				"/videos/video_master.m3u8",
+				"/videos/video_sub0.m3u8",
+				"/videos/video_sub0.vtt",
+				"/videos/video_audio1.m3u8",
+				"/videos/video_audio10.ts",
+				"/videos/video_key0.key",
+			},
+		},
+		"prefixed stream": {
+			"/videos/video2",
+			streamFilesSuffix,
+			files,
+			[]string{"/videos/video2.m3u8", "/videos/video20.ts"},
+		},
+		"keys": {
+			"/videos/video",
+			keysSuffix,
+			files,
+			[]string{"/videos/video_key0.key"},
+		},
+		"metacharacters": {
+			"/videos/clip (1)+[a]",
+			streamFilesSuffix,
+			[]string{"/videos/clip (1)+[a].m3u8", "/videos/clip (1)+[a]0.ts", "/videos/clip 1+a.m3u8", "/videos/clip (1)[a]0.ts"},
+			[]string{"/videos/clip (1)+[a].m3u8", "/videos/clip (1)+[a]0.ts"},
+		},
+		"dot": {
+			"/videos/a.b",
+			streamFilesSuffix,
+			[]string{"/videos/a.b.m3u8", "/videos/aXb.m3u8", "/videos/a.b0.ts"},
+			[]string{"/videos/a.b.m3u8", "/videos/a.b0.ts"},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := matchStreamFiles(testCase.rawName, testCase.suffix, testCase.names)
+			if err != nil {
+				t.Fatalf("matchStreamFiles() error = %s", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("matchStreamFiles() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
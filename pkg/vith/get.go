@@ -2,9 +2,7 @@ package vith
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/ViBiOh/httputils/v4/pkg/httperror"
 	"github.com/ViBiOh/vith/pkg/model"
@@ -32,22 +30,21 @@ func (s Service) HandleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scale := defaultScale
-	if rawScale := r.URL.Query().Get("scale"); len(rawScale) > 0 {
-		scale, err = strconv.ParseUint(r.URL.Query().Get("scale"), 10, 64)
-		if err != nil {
-			httperror.BadRequest(ctx, w, fmt.Errorf("parse scale: %w", err))
-			s.increaseMetric(r.Context(), "http", "thumbnail", "", "invalid")
-			return
-		}
+	options, err := s.parseThumbnailOptions(r)
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(r.Context(), "http", "thumbnail", "", "invalid")
+		return
 	}
 
-	if err := s.storageThumbnail(r.Context(), itemType, r.URL.Path, output, scale); err != nil {
-		httperror.InternalServerError(ctx, w, err)
+	metadata, err := s.storageThumbnail(r.Context(), itemType, r.URL.Path, output, options)
+	if err != nil {
+		handleJobError(ctx, w, err)
 		s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "error")
 		return
 	}
 
+	setPlaceholderHeaders(w.Header(), metadata.placeholder)
 	w.WriteHeader(http.StatusNoContent)
 	s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "success")
 }
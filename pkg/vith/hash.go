@@ -0,0 +1,402 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/bits"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	hashFrameSize   = 32
+	hashSize        = 8
+	videoHashFrames = 10
+)
+
+var hashScaleFilter = fmt.Sprintf("scale=%d:%d:flags=area,format=gray", hashFrameSize, hashFrameSize)
+
+// dctTable holds the DCT-II coefficients of the low frequencies used by the pHash
+var dctTable = func() (table [hashSize][hashFrameSize]float64) {
+	for u := range hashSize {
+		for x := range hashFrameSize {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashFrameSize))
+		}
+	}
+
+	return
+}()
+
+func isHashable(itemType model.ItemType) bool {
+	return itemType == model.TypeImage || itemType == model.TypeVideo
+}
+
+func (s Service) HandleHash(w http.ResponseWriter, r *http.Request) {
+	if !s.hashEnabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !s.storage.Enabled() {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	itemType, err := model.ParseItemType(r.URL.Query().Get("type"))
+	if err != nil || !isHashable(itemType) {
+		httperror.BadRequest(ctx, w, errors.New("hash is possible for image or video type only"))
+		s.increaseMetric(ctx, "http", "hash", "", "invalid")
+		return
+	}
+
+	hash, err := s.storageHash(ctx, itemType, "/"+r.PathValue("input"))
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "hash", itemType.String(), "error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(hash); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "encode hash", slog.Any("error", err))
+	}
+
+	s.increaseMetric(ctx, "http", "hash", itemType.String(), "success")
+}
+
+func (s Service) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	if !s.hashEnabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !s.storage.Enabled() {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	source, target := query.Get("source"), query.Get("target")
+	if len(source) == 0 || len(target) == 0 {
+		httperror.BadRequest(ctx, w, errors.New("source and target query params are mandatory"))
+		s.increaseMetric(ctx, "http", "compare", "", "invalid")
+		return
+	}
+
+	sourceType, err := model.ParseItemType(query.Get("type"))
+	if err != nil || !isHashable(sourceType) {
+		httperror.BadRequest(ctx, w, errors.New("compare is possible for image or video type only"))
+		s.increaseMetric(ctx, "http", "compare", "", "invalid")
+		return
+	}
+
+	targetType := sourceType
+	if rawTargetType := query.Get("targetType"); len(rawTargetType) > 0 {
+		if targetType, err = model.ParseItemType(rawTargetType); err != nil || !isHashable(targetType) {
+			httperror.BadRequest(ctx, w, errors.New("compare is possible for image or video type only"))
+			s.increaseMetric(ctx, "http", "compare", "", "invalid")
+			return
+		}
+	}
+
+	sourceHash, err := s.storageHash(ctx, sourceType, source)
+	if err != nil {
+		handleJobError(ctx, w, fmt.Errorf("hash source: %w", err))
+		s.increaseMetric(ctx, "http", "compare", sourceType.String(), "error")
+		return
+	}
+
+	targetHash, err := s.storageHash(ctx, targetType, target)
+	if err != nil {
+		handleJobError(ctx, w, fmt.Errorf("hash target: %w", err))
+		s.increaseMetric(ctx, "http", "compare", targetType.String(), "error")
+		return
+	}
+
+	similarity, err := hashSimilarity(sourceHash, targetHash)
+	if err != nil {
+		httperror.InternalServerError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "compare", sourceType.String(), "error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(map[string]float64{"similarity": similarity}); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "encode similarity", slog.Any("error", err))
+	}
+
+	s.increaseMetric(ctx, "http", "compare", sourceType.String(), "success")
+}
+
+func (s Service) storageHash(ctx context.Context, itemType model.ItemType, input string) (model.Hash, error) {
+	ctx, done := s.startJob(ctx, "hash", itemType)
+	defer done()
+
+	inputName, finalizeInput, err := s.getInputName(ctx, input)
+	if err != nil {
+		return model.Hash{}, fmt.Errorf("get input name: %w", err)
+	}
+	defer finalizeInput()
+
+	return s.perceptualHash(ctx, itemType, inputName)
+}
+
+// perceptualHash computes the dHash and pHash of an image, or the pHash of keyframes sampled along a video
+func (s Service) perceptualHash(ctx context.Context, itemType model.ItemType, inputName string) (hash model.Hash, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "perceptual_hash")
+	defer end(&err)
+
+	info, infoErr := s.getMediaInfo(ctx, inputName)
+	if infoErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get media info", slog.String("input", inputName), slog.Any("error", infoErr))
+	}
+
+	if itemType == model.TypeImage {
+		frames, err := s.grayFrames(ctx, "-noautorotate", "-i", inputName, "-vf", joinFilters(info.orientation(), hashScaleFilter), "-frames:v", "1")
+		if err != nil {
+			return hash, err
+		}
+
+		hash.DHash = formatHash(dHash(frames[0]))
+		hash.PHash = formatHash(pHash(frames[0]))
+
+		return hash, nil
+	}
+
+	var timestamps []float64
+	if _, duration, durationErr := s.getVideoDetails(ctx, inputName); durationErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video duration", slog.String("input", inputName), slog.Any("error", durationErr))
+	} else {
+		timestamps = hashTimestamps(duration, videoHashFrames)
+	}
+
+	frames, err := s.grayFrames(ctx, keyframeHashArgs(inputName, info.orientation(), timestamps)...)
+	if err != nil {
+		return hash, err
+	}
+
+	for _, frame := range frames {
+		hash.Frames = append(hash.Frames, formatHash(pHash(frame)))
+	}
+
+	hash.PHash = hash.Frames[0]
+
+	return hash, nil
+}
+
+// hashTimestamps spreads the frames at the middle of equal parts of the video
+func hashTimestamps(duration float64, count int) []float64 {
+	if duration <= 0 {
+		return nil
+	}
+
+	timestamps := make([]float64, count)
+	for index := range timestamps {
+		timestamps[index] = duration * (float64(index) + 0.5) / float64(count)
+	}
+
+	return timestamps
+}
+
+// keyframeHashArgs seeks the keyframe before each timestamp, so only these keyframes are decoded instead of the whole video.
+// Without timestamps, the first keyframes are used.
+func keyframeHashArgs(inputName, orientation string, timestamps []float64) []string {
+	scale := joinFilters(orientation, hashScaleFilter)
+
+	if len(timestamps) == 0 {
+		return []string{"-skip_frame", "nokey", "-noautorotate", "-i", inputName, "-vf", scale, "-frames:v", strconv.Itoa(videoHashFrames)}
+	}
+
+	var args []string
+	var graph, inputs strings.Builder
+
+	for index, timestamp := range timestamps {
+		args = append(args, "-skip_frame", "nokey", "-noaccurate_seek", "-ss", fmt.Sprintf("%.3f", timestamp), "-noautorotate", "-i", inputName)
+
+		fmt.Fprintf(&graph, "[%[1]d:v:0]%[2]s,trim=end_frame=1,setpts=PTS-STARTPTS[v%[1]d];", index, scale)
+		fmt.Fprintf(&inputs, "[v%d]", index)
+	}
+
+	fmt.Fprintf(&graph, "%sconcat=n=%d:v=1:a=0[out]", inputs.String(), len(timestamps))
+
+	return append(args, "-filter_complex", graph.String(), "-map", "[out]", "-frames:v", strconv.Itoa(len(timestamps)))
+}
+
+// grayFrames decodes the frames of the given ffmpeg input and filter args as 32x32 grayscale pixels
+func (s Service) grayFrames(ctx context.Context, args ...string) ([][]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, "-an", "-f", "rawvideo", "-pix_fmt", "gray", "pipe:1")...)
+
+	output := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(output)
+
+	errBuffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(errBuffer)
+
+	output.Reset()
+	errBuffer.Reset()
+	cmd.Stdout = output
+	cmd.Stderr = errBuffer
+
+	if err := s.runCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("ffmpeg gray frames: %s: %w", errBuffer.String(), err)
+	}
+
+	frameLength := hashFrameSize * hashFrameSize
+	if output.Len() < frameLength {
+		return nil, errors.New("no frame decoded")
+	}
+
+	var frames [][]byte
+	for content := output.Bytes(); len(content) >= frameLength; content = content[frameLength:] {
+		frames = append(frames, slices.Clone(content[:frameLength]))
+	}
+
+	return frames, nil
+}
+
+// dHash compares adjacent cells of the frame reduced to 9x8
+func dHash(frame []byte) (hash uint64) {
+	var cells [hashSize][hashSize + 1]float64
+
+	for y := range hashSize {
+		for x := range hashSize + 1 {
+			cells[y][x] = cellAverage(frame, x*hashFrameSize/(hashSize+1), (x+1)*hashFrameSize/(hashSize+1), y*hashFrameSize/hashSize, (y+1)*hashFrameSize/hashSize)
+		}
+	}
+
+	for y := range hashSize {
+		for x := range hashSize {
+			hash <<= 1
+			if cells[y][x] < cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func cellAverage(frame []byte, fromX, toX, fromY, toY int) float64 {
+	var sum float64
+
+	for y := fromY; y < toY; y++ {
+		for x := fromX; x < toX; x++ {
+			sum += float64(frame[y*hashFrameSize+x])
+		}
+	}
+
+	return sum / float64((toX-fromX)*(toY-fromY))
+}
+
+// pHash compares the 8x8 lowest frequencies of the frame DCT to their median
+func pHash(frame []byte) (hash uint64) {
+	coefficients := make([]float64, 0, hashSize*hashSize)
+
+	for v := range hashSize {
+		for u := range hashSize {
+			var sum float64
+
+			for y := range hashFrameSize {
+				for x := range hashFrameSize {
+					sum += float64(frame[y*hashFrameSize+x]) * dctTable[u][x] * dctTable[v][y]
+				}
+			}
+
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	// the DC coefficient is the mean brightness, it's excluded from the median
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	for _, coefficient := range coefficients {
+		hash <<= 1
+		if coefficient > median {
+			hash |= 1
+		}
+	}
+
+	return hash
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parseHashes(hash model.Hash) ([]uint64, error) {
+	raw := hash.Frames
+	if len(raw) == 0 {
+		raw = []string{hash.PHash}
+	}
+
+	output := make([]uint64, len(raw))
+
+	for index, value := range raw {
+		parsed, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse hash `%s`: %w", value, err)
+		}
+
+		output[index] = parsed
+	}
+
+	return output, nil
+}
+
+// hashSimilarity returns a score between 0 and 1, comparing frames at the same relative position of both items,
+// or the best matching frame when one of them is a single image
+func hashSimilarity(source, target model.Hash) (float64, error) {
+	sourceHashes, err := parseHashes(source)
+	if err != nil {
+		return 0, err
+	}
+
+	targetHashes, err := parseHashes(target)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(sourceHashes) > len(targetHashes) {
+		sourceHashes, targetHashes = targetHashes, sourceHashes
+	}
+
+	if len(sourceHashes) == 1 {
+		var best float64
+
+		for _, hash := range targetHashes {
+			best = math.Max(best, similarity(sourceHashes[0], hash))
+		}
+
+		return best, nil
+	}
+
+	var sum float64
+
+	for index, hash := range sourceHashes {
+		sum += similarity(hash, targetHashes[index*len(targetHashes)/len(sourceHashes)])
+	}
+
+	return sum / float64(len(sourceHashes)), nil
+}
+
+func similarity(source, target uint64) float64 {
+	return 1 - float64(bits.OnesCount64(source^target))/64
+}
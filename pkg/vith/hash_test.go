@@ -0,0 +1,222 @@
+package vith
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+// gradientFrame is brighter to the right, or to the bottom when vertical
+func gradientFrame(vertical bool) []byte {
+	frame := make([]byte, hashFrameSize*hashFrameSize)
+
+	for y := range hashFrameSize {
+		for x := range hashFrameSize {
+			value := x
+			if vertical {
+				value = y
+			}
+
+			frame[y*hashFrameSize+x] = byte(value * 8)
+		}
+	}
+
+	return frame
+}
+
+func TestDHash(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		frame []byte
+		want  string
+	}{
+		"flat": {
+			make([]byte, hashFrameSize*hashFrameSize),
+			"0000000000000000",
+		},
+		"horizontal gradient": {
+			gradientFrame(false),
+			"ffffffffffffffff",
+		},
+		"vertical gradient": {
+			gradientFrame(true),
+			"0000000000000000",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := formatHash(dHash(testCase.frame)); got != testCase.want {
+				t.Errorf("dHash() = %s, want %s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPHash(t *testing.T) {
+	t.Parallel()
+
+	horizontal := pHash(gradientFrame(false))
+	vertical := pHash(gradientFrame(true))
+
+	if horizontal == vertical {
+		t.Errorf("pHash() = %s for both gradients", formatHash(horizontal))
+	}
+
+	if got := pHash(gradientFrame(false)); got != horizontal {
+		t.Errorf("pHash() = %s, want stable %s", formatHash(got), formatHash(horizontal))
+	}
+}
+
+func TestHashSimilarity(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		source  model.Hash
+		target  model.Hash
+		want    float64
+		wantErr bool
+	}{
+		"same image": {
+			model.Hash{PHash: "00000000ffffffff"},
+			model.Hash{PHash: "00000000ffffffff"},
+			1,
+			false,
+		},
+		"opposite images": {
+			model.Hash{PHash: "0000000000000000"},
+			model.Hash{PHash: "ffffffffffffffff"},
+			0,
+			false,
+		},
+		"one bit out of four": {
+			model.Hash{PHash: "0000000000000000"},
+			model.Hash{PHash: "1111111111111111"},
+			0.75,
+			false,
+		},
+		"image in video": {
+			model.Hash{PHash: "00000000000000ff"},
+			model.Hash{PHash: "ffffffffffffffff", Frames: []string{"ffffffffffffffff", "00000000000000ff", "0000000000000000"}},
+			1,
+			false,
+		},
+		"videos of different lengths": {
+			model.Hash{Frames: []string{"0000000000000000", "ffffffffffffffff"}},
+			model.Hash{Frames: []string{"0000000000000000", "0000000000000000", "ffffffffffffffff", "ffffffffffffffff"}},
+			1,
+			false,
+		},
+		"videos at different positions": {
+			model.Hash{Frames: []string{"0000000000000000", "ffffffffffffffff"}},
+			model.Hash{Frames: []string{"ffffffffffffffff", "0000000000000000"}},
+			0,
+			false,
+		},
+		"invalid": {
+			model.Hash{PHash: "vith"},
+			model.Hash{PHash: "0000000000000000"},
+			0,
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := hashSimilarity(testCase.source, testCase.target)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("hashSimilarity() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			if got != testCase.want {
+				t.Errorf("hashSimilarity() = %g, want %g", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHashTimestamps(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		duration float64
+		count    int
+		want     []float64
+	}{
+		"unknown duration": {
+			0,
+			4,
+			nil,
+		},
+		"spread": {
+			40,
+			4,
+			[]float64{5, 15, 25, 35},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := hashTimestamps(testCase.duration, testCase.count); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("hashTimestamps() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestKeyframeHashArgs(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		orientation string
+		timestamps  []float64
+		want        []string
+	}{
+		"first keyframes": {
+			"",
+			nil,
+			[]string{"-skip_frame", "nokey", "-noautorotate", "-i", "/tmp/input", "-vf", "scale=32:32:flags=area,format=gray", "-frames:v", "10"},
+		},
+		"seeked keyframes": {
+			"transpose=clock",
+			[]float64{5, 15},
+			[]string{
+				"-skip_frame", "nokey", "-noaccurate_seek", "-ss", "5.000", "-noautorotate", "-i", "/tmp/input",
+				"-skip_frame", "nokey", "-noaccurate_seek", "-ss", "15.000", "-noautorotate", "-i", "/tmp/input",
+				"-filter_complex", "[0:v:0]transpose=clock,scale=32:32:flags=area,format=gray,trim=end_frame=1,setpts=PTS-STARTPTS[v0];[1:v:0]transpose=clock,scale=32:32:flags=area,format=gray,trim=end_frame=1,setpts=PTS-STARTPTS[v1];[v0][v1]concat=n=2:v=1:a=0[out]",
+				"-map", "[out]", "-frames:v", "2",
+			},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := keyframeHashArgs("/tmp/input", testCase.orientation, testCase.timestamps); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("keyframeHashArgs() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHandleHashDisabled(t *testing.T) {
+	t.Parallel()
+
+	writer := httptest.NewRecorder()
+	Service{}.HandleHash(writer, httptest.NewRequest(http.MethodGet, "/_/hash/image.jpg?type=image", nil))
+
+	if writer.Code != http.StatusNotFound {
+		t.Errorf("HandleHash() = %d, want %d", writer.Code, http.StatusNotFound)
+	}
+}
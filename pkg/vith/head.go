@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"os/exec"
 	"strconv"
@@ -29,19 +30,33 @@ func (s Service) HandleHead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if itemType != model.TypeVideo {
-		httperror.BadRequest(ctx, w, errors.New("bitrate is possible for video type only"))
+	if itemType == model.TypeImage {
+		httperror.BadRequest(ctx, w, errors.New("probe is possible for video, audio or document type only"))
 		return
 	}
 
+	ctx, done := s.startJob(ctx, "probe", itemType)
+	defer done()
+
+	r = r.WithContext(ctx)
+
 	inputName, finalizeInput, err := s.getInputName(ctx, r.URL.Path)
 	if err != nil {
-		httperror.InternalServerError(ctx, w, fmt.Errorf("get input name: %w", err))
+		handleJobError(ctx, w, fmt.Errorf("get input name: %w", err))
 		return
 	}
 
 	defer finalizeInput()
 
+	switch itemType {
+	case model.TypeAudio:
+		s.handleAudioHead(w, r, inputName)
+		return
+	case model.TypeDocument:
+		s.handleDocumentHead(w, r, inputName)
+		return
+	}
+
 	bitrate, duration, err := s.getVideoDetails(ctx, inputName)
 	if err != nil {
 		httperror.InternalServerError(ctx, w, fmt.Errorf("get bitrate: %w", err))
@@ -51,6 +66,84 @@ func (s Service) HandleHead(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Vith-Bitrate", fmt.Sprintf("%d", bitrate))
 	w.Header().Set("X-Vith-Duration", fmt.Sprintf("%.3f", duration))
 
+	audioTracks, err := s.getTracks(ctx, inputName, "a")
+	if err != nil {
+		httperror.InternalServerError(ctx, w, fmt.Errorf("get audio tracks: %w", err))
+		return
+	}
+
+	for _, track := range audioTracks {
+		w.Header().Add("X-Vith-Audio", fmt.Sprintf("%s; codec=%s; title=%s", track.language(), track.Codec, mime.QEncoding.Encode("utf-8", track.Title)))
+	}
+
+	subtitleTracks, err := s.getTracks(ctx, inputName, "s")
+	if err != nil {
+		httperror.InternalServerError(ctx, w, fmt.Errorf("get subtitles: %w", err))
+		return
+	}
+
+	for _, track := range subtitleTracks {
+		w.Header().Add("X-Vith-Subtitle", fmt.Sprintf("%s; codec=%s; text=%t; title=%s", track.language(), track.Codec, track.isText(), mime.QEncoding.Encode("utf-8", track.Title)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s Service) handleAudioHead(w http.ResponseWriter, r *http.Request, inputName string) {
+	info, err := s.getAudioDetails(r.Context(), inputName)
+	if err != nil {
+		httperror.InternalServerError(r.Context(), w, fmt.Errorf("get audio details: %w", err))
+		return
+	}
+
+	w.Header().Set("X-Vith-Bitrate", fmt.Sprintf("%d", info.Bitrate))
+	w.Header().Set("X-Vith-Duration", fmt.Sprintf("%.3f", info.Duration))
+	w.Header().Set("X-Vith-Codec", info.Codec)
+
+	for key, value := range info.Tags {
+		if name, ok := audioTagHeader(key); ok {
+			w.Header().Set(name, mime.QEncoding.Encode("utf-8", value))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// audioTagHeaders maps the known ffprobe tags, lowercased as containers differ in case, to their header name
+var audioTagHeaders = map[string]string{
+	"title":        "Title",
+	"artist":       "Artist",
+	"album":        "Album",
+	"album_artist": "Album-Artist",
+	"composer":     "Composer",
+	"genre":        "Genre",
+	"date":         "Date",
+	"track":        "Track",
+	"disc":         "Disc",
+	"comment":      "Comment",
+	"copyright":    "Copyright",
+	"language":     "Language",
+}
+
+// audioTagHeader returns the header of a known tag, others are dropped as their key can be any string written in the file
+func audioTagHeader(key string) (string, bool) {
+	name, ok := audioTagHeaders[strings.ToLower(key)]
+	if !ok {
+		return "", false
+	}
+
+	return "X-Vith-Tag-" + name, true
+}
+
+func (s Service) handleDocumentHead(w http.ResponseWriter, r *http.Request, inputName string) {
+	pages, err := s.getDocumentPages(r.Context(), inputName)
+	if err != nil {
+		httperror.InternalServerError(r.Context(), w, fmt.Errorf("get document pages: %w", err))
+		return
+	}
+
+	w.Header().Set("X-Vith-Pages", strconv.FormatUint(pages, 10))
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -67,7 +160,7 @@ func (s Service) getVideoDetails(ctx context.Context, inputName string) (bireate
 	cmd.Stdout = buffer
 	cmd.Stderr = buffer
 
-	if err = cmd.Run(); err != nil {
+	if err = s.runCommand(ctx, cmd); err != nil {
 		return 0, 0.0, fmt.Errorf("ffprobe error `%s`: %s", err, buffer.String())
 	}
 
@@ -0,0 +1,50 @@
+package vith
+
+import "testing"
+
+func TestAudioTagHeader(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		"known": {
+			"title",
+			"X-Vith-Tag-Title",
+			true,
+		},
+		"uppercase vorbis comment": {
+			"ALBUM_ARTIST",
+			"X-Vith-Tag-Album-Artist",
+			true,
+		},
+		"unknown": {
+			"encoder",
+			"",
+			false,
+		},
+		"invalid token": {
+			"iTunes Comment\r\nSet-Cookie: session",
+			"",
+			false,
+		},
+		"empty": {
+			"",
+			"",
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := audioTagHeader(testCase.key)
+			if got != testCase.want || ok != testCase.wantOk {
+				t.Errorf("audioTagHeader() = (`%s`, %t), want (`%s`, %t)", got, ok, testCase.want, testCase.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package vith
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+)
+
+const defaultVariantBandwidth = 2_000_000
+
+type hlsRendition struct {
+	kind      string
+	group     string
+	name      string
+	language  string
+	uri       string
+	isDefault bool
+}
+
+type hlsVariant struct {
+	uri        string
+	videoRange string
+	bandwidth  int64
+}
+
+// writeMasterPlaylist writes the playlist referencing every variant and rendition of the stream, next to the main manifest
+func writeMasterPlaylist(outputName string, variants []hlsVariant, renditions []hlsRendition) error {
+	groups := make(map[string]string)
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, rendition := range renditions {
+		groups[rendition.kind] = rendition.group
+
+		fmt.Fprintf(&playlist, "#EXT-X-MEDIA:TYPE=%s,GROUP-ID=%q,NAME=%q,LANGUAGE=%q,DEFAULT=%s,AUTOSELECT=YES", rendition.kind, rendition.group, rendition.name, rendition.language, yesNo(rendition.isDefault))
+
+		if len(rendition.uri) > 0 {
+			fmt.Fprintf(&playlist, ",URI=%q", rendition.uri)
+		}
+
+		playlist.WriteString("\n")
+	}
+
+	for _, variant := range variants {
+		bandwidth := variant.bandwidth
+		if bandwidth <= 0 {
+			bandwidth = defaultVariantBandwidth
+		}
+
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+
+		if len(variant.videoRange) > 0 {
+			fmt.Fprintf(&playlist, ",VIDEO-RANGE=%s", variant.videoRange)
+		}
+
+		for _, kind := range []string{"AUDIO", "SUBTITLES"} {
+			if group, ok := groups[kind]; ok {
+				fmt.Fprintf(&playlist, ",%s=%q", kind, group)
+			}
+		}
+
+		playlist.WriteString("\n" + variant.uri + "\n")
+	}
+
+	if err := os.WriteFile(masterStreamName(outputName), []byte(playlist.String()), absto.RegularFilePerm); err != nil {
+		return fmt.Errorf("write master playlist: %w", err)
+	}
+
+	return nil
+}
+
+func masterStreamName(name string) string {
+	return strings.TrimSuffix(name, hlsExtension) + masterSuffix + hlsExtension
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "YES"
+	}
+
+	return "NO"
+}
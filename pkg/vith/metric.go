@@ -2,20 +2,195 @@ package vith
 
 import (
 	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
 
+	"github.com/ViBiOh/vith/pkg/model"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
 
+type operationKey struct{}
+
+type operation struct {
+	name     string
+	itemType string
+}
+
+type metrics struct {
+	item            metric.Int64Counter
+	runningJobs     metric.Int64UpDownCounter
+	processDuration metric.Float64Histogram
+	processCPU      metric.Float64Histogram
+	processMemory   metric.Int64Histogram
+	inputSize       metric.Int64Histogram
+	outputSize      metric.Int64Histogram
+	queueWait       metric.Float64Histogram
+	tmpRemoved      metric.Int64Counter
+	tmpReclaimed    metric.Int64Counter
+	tmpAdmission    metric.Int64Counter
+	cache           metric.Int64Counter
+}
+
+func newMetrics(meter metric.Meter, queue chan queuedRequest, budget *tmpBudget) (output metrics) {
+	var err error
+
+	logError := func(name string, err error) {
+		if err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelError, "create vith metric", slog.String("name", name), slog.Any("error", err))
+		}
+	}
+
+	output.item, err = meter.Int64Counter("vith.item")
+	logError("vith.item", err)
+
+	output.runningJobs, err = meter.Int64UpDownCounter("vith.jobs.running", metric.WithDescription("Operations in progress"))
+	logError("vith.jobs.running", err)
+
+	output.processDuration, err = meter.Float64Histogram("vith.process.duration", metric.WithUnit("s"), metric.WithDescription("Wall time of child processes"))
+	logError("vith.process.duration", err)
+
+	output.processCPU, err = meter.Float64Histogram("vith.process.cpu", metric.WithUnit("s"), metric.WithDescription("User and system CPU time of child processes"))
+	logError("vith.process.cpu", err)
+
+	output.processMemory, err = meter.Int64Histogram("vith.process.memory", metric.WithUnit("By"), metric.WithDescription("Maximum resident set size of child processes"))
+	logError("vith.process.memory", err)
+
+	output.inputSize, err = meter.Int64Histogram("vith.input.size", metric.WithUnit("By"))
+	logError("vith.input.size", err)
+
+	output.outputSize, err = meter.Int64Histogram("vith.output.size", metric.WithUnit("By"))
+	logError("vith.output.size", err)
+
+	output.queueWait, err = meter.Float64Histogram("vith.queue.wait", metric.WithUnit("s"), metric.WithDescription("Time spent by stream requests in queue"))
+	logError("vith.queue.wait", err)
+
+	output.tmpRemoved, err = meter.Int64Counter("vith.tmp.removed", metric.WithDescription("Stale temporary files removed"))
+	logError("vith.tmp.removed", err)
+
+	output.tmpReclaimed, err = meter.Int64Counter("vith.tmp.reclaimed", metric.WithUnit("By"), metric.WithDescription("Size of stale temporary files removed"))
+	logError("vith.tmp.reclaimed", err)
+
+	output.tmpAdmission, err = meter.Int64Counter("vith.tmp.admission", metric.WithDescription("Temporary disk space reservations, by state: accepted, delayed or rejected"))
+	logError("vith.tmp.admission", err)
+
+	output.cache, err = meter.Int64Counter("vith.cache", metric.WithDescription("Thumbnail cache lookups, by result: hit or miss"))
+	logError("vith.cache", err)
+
+	_, err = meter.Int64ObservableGauge("vith.tmp.reserved", metric.WithUnit("By"), metric.WithDescription("Temporary disk space reserved by running jobs"), metric.WithInt64Callback(observeTmpReserved(budget)))
+	logError("vith.tmp.reserved", err)
+
+	_, err = meter.Int64ObservableGauge("vith.queue.depth", metric.WithDescription("Stream requests waiting in queue"), metric.WithInt64Callback(func(_ context.Context, observer metric.Int64Observer) error {
+		observer.Observe(int64(len(queue)))
+		return nil
+	}))
+	logError("vith.queue.depth", err)
+
+	return output
+}
+
 func (s Service) increaseMetric(ctx context.Context, source, kind, itemType, state string) {
-	if s.metric == nil {
+	if s.metrics.item == nil {
 		return
 	}
 
-	s.metric.Add(ctx, 1, metric.WithAttributes(
+	s.metrics.item.Add(ctx, 1, metric.WithAttributes(
 		attribute.String("source", source),
 		attribute.String("kind", kind),
 		attribute.String("itemType", itemType),
 		attribute.String("state", state),
 	))
 }
+
+func withOperation(ctx context.Context, name string, itemType model.ItemType) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation{name: name, itemType: itemType.String()})
+}
+
+// startJob labels the context with the operation for the metrics recorded downstream and counts it as running until the returned func is called
+func (s Service) startJob(ctx context.Context, name string, itemType model.ItemType) (context.Context, func()) {
+	ctx = withOperation(ctx, name, itemType)
+
+	if s.metrics.runningJobs == nil {
+		return ctx, noopFunc
+	}
+
+	attributes := operationAttributes(ctx)
+	s.metrics.runningJobs.Add(ctx, 1, attributes)
+
+	return ctx, func() {
+		s.metrics.runningJobs.Add(context.WithoutCancel(ctx), -1, attributes)
+	}
+}
+
+func operationAttributes(ctx context.Context, attributes ...attribute.KeyValue) metric.MeasurementOption {
+	op, _ := ctx.Value(operationKey{}).(operation)
+
+	return metric.WithAttributes(append(attributes, attribute.String("operation", op.name), attribute.String("itemType", op.itemType))...)
+}
+
+// runCommand runs the command and records its wall time and resources usage
+func (s Service) runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Run()
+
+	if s.metrics.processDuration == nil {
+		return err
+	}
+
+	attributes := operationAttributes(ctx, attribute.String("command", cmd.Path))
+
+	s.metrics.processDuration.Record(ctx, time.Since(start).Seconds(), attributes)
+
+	if cmd.ProcessState != nil {
+		s.metrics.processCPU.Record(ctx, (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds(), attributes)
+
+		if rss := maxRSS(cmd.ProcessState); rss > 0 {
+			s.metrics.processMemory.Record(ctx, rss, attributes)
+		}
+	}
+
+	return err
+}
+
+func (s Service) recordInputSize(ctx context.Context, names ...string) {
+	s.recordSize(ctx, s.metrics.inputSize, names...)
+}
+
+func (s Service) recordOutputSize(ctx context.Context, names ...string) {
+	s.recordSize(ctx, s.metrics.outputSize, names...)
+}
+
+func (s Service) recordSize(ctx context.Context, histogram metric.Int64Histogram, names ...string) {
+	if histogram == nil {
+		return
+	}
+
+	var size int64
+
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			size += info.Size()
+		}
+	}
+
+	histogram.Record(ctx, size, operationAttributes(ctx))
+}
+
+func (s Service) recordQueueWait(ctx context.Context, enqueuedAt time.Time) {
+	if s.metrics.queueWait == nil {
+		return
+	}
+
+	s.metrics.queueWait.Record(ctx, time.Since(enqueuedAt).Seconds(), operationAttributes(ctx))
+}
+
+func (s Service) recordSweep(ctx context.Context, files, size int64) {
+	if s.metrics.tmpRemoved == nil {
+		return
+	}
+
+	s.metrics.tmpRemoved.Add(ctx, files)
+	s.metrics.tmpReclaimed.Add(ctx, size)
+}
@@ -0,0 +1,218 @@
+package vith
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ViBiOh/vith/pkg/model"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func metricService(t *testing.T) (Service, *sdkmetric.ManualReader) {
+	t.Helper()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return Service{metrics: newMetrics(provider.Meter("test"), make(chan queuedRequest), nil)}, reader
+}
+
+func collectMetric(t *testing.T, reader *sdkmetric.ManualReader, name string) metricdata.Aggregation {
+	t.Helper()
+
+	var output metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range output.ScopeMetrics {
+		for _, item := range scope.Metrics {
+			if item.Name == name {
+				return item.Data
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasAttributes(set attribute.Set, want map[string]string) bool {
+	if set.Len() != len(want) {
+		return false
+	}
+
+	for key, value := range want {
+		if got, ok := set.Value(attribute.Key(key)); !ok || got.AsString() != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestStartJob(t *testing.T) {
+	t.Parallel()
+
+	service, reader := metricService(t)
+	want := map[string]string{"operation": "preview", "itemType": model.TypeVideo.String()}
+
+	running := func() int64 {
+		sum, ok := collectMetric(t, reader, "vith.jobs.running").(metricdata.Sum[int64])
+		if !ok || len(sum.DataPoints) != 1 {
+			t.Fatalf("vith.jobs.running = %+v, want a single data point", sum)
+		}
+
+		if point := sum.DataPoints[0]; !hasAttributes(point.Attributes, want) {
+			t.Errorf("vith.jobs.running attributes = %v, want %v", point.Attributes.ToSlice(), want)
+		}
+
+		return sum.DataPoints[0].Value
+	}
+
+	ctx, done := service.startJob(context.Background(), "preview", model.TypeVideo)
+
+	if op, _ := ctx.Value(operationKey{}).(operation); op.name != "preview" || op.itemType != model.TypeVideo.String() {
+		t.Errorf("startJob() operation = %+v, want preview of video", op)
+	}
+
+	if got := running(); got != 1 {
+		t.Errorf("vith.jobs.running = %d, want 1", got)
+	}
+
+	done()
+
+	if got := running(); got != 0 {
+		t.Errorf("vith.jobs.running = %d, want 0", got)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		cmd           *exec.Cmd
+		wantErr       bool
+		wantResources bool
+	}{
+		"success": {
+			// runs no test, so it exits straight away
+			exec.Command(os.Args[0], "-test.run=^$"),
+			false,
+			true,
+		},
+		"not found": {
+			exec.Command(filepath.Join(t.TempDir(), "ffmpeg")),
+			true,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			service, reader := metricService(t)
+			ctx := withOperation(context.Background(), "thumbnail", model.TypeImage)
+
+			if err := service.runCommand(ctx, testCase.cmd); (err != nil) != testCase.wantErr {
+				t.Errorf("runCommand() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			want := map[string]string{"operation": "thumbnail", "itemType": model.TypeImage.String(), "command": testCase.cmd.Path}
+
+			duration, ok := collectMetric(t, reader, "vith.process.duration").(metricdata.Histogram[float64])
+			if !ok || len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 1 {
+				t.Fatalf("vith.process.duration = %+v, want a single measure", duration)
+			}
+
+			if !hasAttributes(duration.DataPoints[0].Attributes, want) {
+				t.Errorf("vith.process.duration attributes = %v, want %v", duration.DataPoints[0].Attributes.ToSlice(), want)
+			}
+
+			cpu, _ := collectMetric(t, reader, "vith.process.cpu").(metricdata.Histogram[float64])
+			if got := len(cpu.DataPoints) == 1; got != testCase.wantResources {
+				t.Errorf("vith.process.cpu recorded = %t, want %t", got, testCase.wantResources)
+			}
+		})
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	first := filepath.Join(root, "first")
+	if err := os.WriteFile(first, []byte("abc"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	second := filepath.Join(root, "second")
+	if err := os.WriteFile(second, []byte("vith!"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	service, reader := metricService(t)
+	ctx := withOperation(context.Background(), "stream", model.TypeVideo)
+	want := map[string]string{"operation": "stream", "itemType": model.TypeVideo.String()}
+
+	service.recordInputSize(ctx, first)
+	service.recordOutputSize(ctx, first, second, filepath.Join(root, "missing"))
+
+	cases := map[string]struct {
+		name string
+		want int64
+	}{
+		"input": {
+			"vith.input.size",
+			3,
+		},
+		"output": {
+			"vith.output.size",
+			8,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			histogram, ok := collectMetric(t, reader, testCase.name).(metricdata.Histogram[int64])
+			if !ok || len(histogram.DataPoints) != 1 {
+				t.Fatalf("%s = %+v, want a single data point", testCase.name, histogram)
+			}
+
+			point := histogram.DataPoints[0]
+
+			if point.Count != 1 || point.Sum != testCase.want {
+				t.Errorf("%s = %d measures of %d bytes, want 1 of %d", testCase.name, point.Count, point.Sum, testCase.want)
+			}
+
+			if !hasAttributes(point.Attributes, want) {
+				t.Errorf("%s attributes = %v, want %v", testCase.name, point.Attributes.ToSlice(), want)
+			}
+		})
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	t.Parallel()
+
+	ctx, done := Service{}.startJob(context.Background(), "clip", model.TypeVideo)
+	defer done()
+
+	if op, _ := ctx.Value(operationKey{}).(operation); op.name != "clip" {
+		t.Errorf("startJob() operation = %+v, want clip", op)
+	}
+
+	Service{}.recordInputSize(ctx, os.Args[0])
+}
@@ -0,0 +1,343 @@
+package vith
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	multipartOptionsField = "options"
+	multipartOptionsSize  = 64 << 10
+
+	zipContentType = "application/zip"
+	zipManifest    = "manifest.json"
+)
+
+// postOptions is the JSON options part of a multipart POST, overriding the query params
+type postOptions struct {
+	Overlay *model.Overlay `json:"overlay,omitempty"`
+	Type    string         `json:"type,omitempty"`
+	Scale   uint64         `json:"scale,omitempty"`
+	Page    uint64         `json:"page,omitempty"`
+}
+
+type postFile struct {
+	hash      hash.Hash
+	name      string
+	inputName string
+}
+
+type postResult struct {
+	Placeholder *model.Placeholder `json:"placeholder,omitempty"`
+	Input       string             `json:"input"`
+	Output      string             `json:"output,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// thumbnailArchive writes the thumbnails of a multipart POST in the response
+type thumbnailArchive interface {
+	add(result postResult, content io.Reader) error
+	fail(result postResult) error
+	Close() error
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// handleMultipartPost generates the thumbnail of every file part, in a multipart/mixed or a ZIP response
+func (s Service) handleMultipartPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// the body is limited before the multipart reader wraps it
+	release, err := s.reserveBody(ctx, w, r)
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "thumbnail", "", "rejected")
+		return
+	}
+	defer release()
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "thumbnail", "", "invalid")
+		return
+	}
+
+	options, err := parsePostOptions(r)
+
+	var files []postFile
+
+	defer func() {
+		for _, file := range files {
+			cleanLocalFile(ctx, file.inputName)
+		}
+	}()
+
+	if err == nil {
+		files, err = s.readMultipartFiles(ctx, reader, &options)
+	}
+
+	if err == nil && len(files) == 0 {
+		err = errors.New("no file in multipart body")
+	}
+
+	if err == nil && options.Overlay != nil {
+		err = validateOverlay(*options.Overlay)
+	}
+
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "thumbnail", "", "invalid")
+		return
+	}
+
+	var archive thumbnailArchive
+
+	if strings.Contains(r.Header.Get("Accept"), zipContentType) {
+		w.Header().Set("Content-Type", zipContentType)
+		archive = &zipArchive{writer: zip.NewWriter(w)}
+	} else {
+		writer := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		archive = multipartArchive{writer: writer}
+	}
+
+	thumbnailOptions := newThumbnailOptions(options.Scale, options.Page, s.overlayOrDefault(options.Overlay))
+	outputNames := make(map[string]bool)
+
+	for _, file := range files {
+		itemType, err := postItemType(options.Type, file.name)
+		if err != nil {
+			s.increaseMetric(ctx, "http", "thumbnail", "", "invalid")
+			err = archive.fail(postResult{Input: file.name, Error: err.Error()})
+		} else {
+			err = s.multipartThumbnail(ctx, archive, file, itemType, thumbnailOptions, outputNames)
+		}
+
+		if err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "write multipart response", slog.String("input", file.name), slog.Any("error", err))
+			break
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "close multipart response", slog.Any("error", err))
+	}
+}
+
+// parsePostOptions reads the query params, as defaults of the options part
+func parsePostOptions(r *http.Request) (options postOptions, err error) {
+	options.Type = r.URL.Query().Get("type")
+
+	if options.Scale, err = parseUintParam(r, "scale"); err != nil {
+		return options, err
+	}
+
+	if options.Page, err = parseUintParam(r, "page"); err != nil {
+		return options, err
+	}
+
+	options.Overlay, err = parseOverlay(r)
+
+	return options, err
+}
+
+// readMultipartFiles saves the file parts locally and decodes the options part
+func (s Service) readMultipartFiles(ctx context.Context, reader *multipart.Reader, options *postOptions) ([]postFile, error) {
+	var files []postFile
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+
+		if err != nil {
+			return files, fmt.Errorf("read part: %w", err)
+		}
+
+		if len(part.FileName()) == 0 {
+			if part.FormName() == multipartOptionsField {
+				if err = json.NewDecoder(io.LimitReader(part, multipartOptionsSize)).Decode(options); err != nil {
+					return files, fmt.Errorf("decode options: %w", err)
+				}
+			}
+
+			closeWithLog(ctx, part, "readMultipartFiles", part.FormName())
+
+			continue
+		}
+
+		file := postFile{name: path.Base(part.FileName()), hash: sha256.New()}
+
+		file.inputName, err = s.saveFileLocally(ctx, struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(part, file.hash), part}, fmt.Sprintf("%s_%d_%s", time.Now(), len(files), file.name))
+
+		files = append(files, file)
+
+		if err != nil {
+			return files, fmt.Errorf("save `%s`: %w", file.name, err)
+		}
+	}
+}
+
+// multipartThumbnail adds the thumbnail of the file to the archive, or its error, only failing when the archive can't be written
+func (s Service) multipartThumbnail(ctx context.Context, archive thumbnailArchive, file postFile, itemType model.ItemType, options thumbnailOptions, outputNames map[string]bool) error {
+	ctx, done := s.startJob(ctx, "thumbnail", itemType)
+	defer done()
+
+	result := postResult{Input: file.name}
+
+	var reader io.ReadCloser
+	var metadata cacheMetadata
+
+	key, err := s.cacheKey(ctx, file.hash, itemType, options)
+	if err == nil {
+		reader, metadata, err = s.keyedThumbnail(ctx, file.inputName, key, itemType, options)
+	}
+
+	if err != nil {
+		s.increaseMetric(ctx, "http", "thumbnail", itemType.String(), "error")
+
+		result.Error = err.Error()
+		return archive.fail(result)
+	}
+	defer closeWithLog(ctx, reader, "multipartThumbnail", key)
+
+	result.Output = uniqueOutputName(file.name, outputNames)
+	result.Placeholder = metadata.Placeholder
+
+	if err = archive.add(result, reader); err != nil {
+		return err
+	}
+
+	s.increaseMetric(ctx, "http", "thumbnail", itemType.String(), "success")
+
+	return nil
+}
+
+// postItemType parses the type of the options, or guesses it from the extension of the file
+func postItemType(rawType, name string) (model.ItemType, error) {
+	if len(rawType) != 0 {
+		return model.ParseItemType(rawType)
+	}
+
+	if itemType, ok := extensionItemTypes[strings.ToLower(path.Ext(name))]; ok {
+		return itemType, nil
+	}
+
+	return 0, fmt.Errorf("unknown type of `%s`, type option is mandatory", name)
+}
+
+func uniqueOutputName(name string, outputNames map[string]bool) string {
+	raw := strings.TrimSuffix(name, path.Ext(name))
+
+	output := raw + webpExtension
+	for index := 1; outputNames[output]; index++ {
+		output = fmt.Sprintf("%s_%d%s", raw, index, webpExtension)
+	}
+
+	outputNames[output] = true
+
+	return output
+}
+
+type multipartArchive struct {
+	writer *multipart.Writer
+}
+
+func (ma multipartArchive) add(result postResult, content io.Reader) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "image/webp")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": result.Output}))
+	setPlaceholderHeaders(http.Header(header), result.Placeholder)
+
+	part, err := ma.writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("create part: %w", err)
+	}
+
+	_, err = io.Copy(part, content)
+	return err
+}
+
+func (ma multipartArchive) fail(result postResult) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": result.Input}))
+	header.Set("X-Vith-Error", "true")
+
+	part, err := ma.writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("create part: %w", err)
+	}
+
+	_, err = io.WriteString(part, result.Error)
+	return err
+}
+
+func (ma multipartArchive) Close() error {
+	return ma.writer.Close()
+}
+
+// zipArchive stores the thumbnails without compression, WebP being already compressed, and lists them with errors in a manifest
+type zipArchive struct {
+	writer  *zip.Writer
+	results []postResult
+}
+
+func (za *zipArchive) add(result postResult, content io.Reader) error {
+	entry, err := za.writer.CreateHeader(&zip.FileHeader{Name: result.Output, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("create entry: %w", err)
+	}
+
+	if _, err = io.Copy(entry, content); err != nil {
+		return err
+	}
+
+	za.results = append(za.results, result)
+
+	return nil
+}
+
+func (za *zipArchive) fail(result postResult) error {
+	za.results = append(za.results, result)
+
+	return nil
+}
+
+func (za *zipArchive) Close() error {
+	entry, err := za.writer.CreateHeader(&zip.FileHeader{Name: zipManifest, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+
+	if err = json.NewEncoder(entry).Encode(za.results); err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	return za.writer.Close()
+}
@@ -0,0 +1,136 @@
+package vith
+
+import (
+	"testing"
+
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+func TestPostItemType(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		rawType string
+		name    string
+		want    model.ItemType
+		wantErr bool
+	}{
+		"explicit": {
+			"audio",
+			"recording",
+			model.TypeAudio,
+			false,
+		},
+		"explicit over extension": {
+			"document",
+			"scan.jpg",
+			model.TypeDocument,
+			false,
+		},
+		"invalid type": {
+			"picture",
+			"image.jpg",
+			0,
+			true,
+		},
+		"image": {
+			"",
+			"image.jpg",
+			model.TypeImage,
+			false,
+		},
+		"upper case extension": {
+			"",
+			"IMG_0001.HEIC",
+			model.TypeImage,
+			false,
+		},
+		"video": {
+			"",
+			"holidays.2024.mp4",
+			model.TypeVideo,
+			false,
+		},
+		"document": {
+			"",
+			"report.pdf",
+			model.TypeDocument,
+			false,
+		},
+		"unknown extension": {
+			"",
+			"notes.txt",
+			0,
+			true,
+		},
+		"no extension": {
+			"",
+			"image",
+			0,
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := postItemType(testCase.rawType, testCase.name)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("postItemType() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			if !testCase.wantErr && got != testCase.want {
+				t.Errorf("postItemType() = %s, want %s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestUniqueOutputName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		names []string
+		want  []string
+	}{
+		"distinct": {
+			[]string{"first.jpg", "second.png"},
+			[]string{"first.webp", "second.webp"},
+		},
+		"same name": {
+			[]string{"image.jpg", "image.jpg", "image.jpg"},
+			[]string{"image.webp", "image_1.webp", "image_2.webp"},
+		},
+		"same base name": {
+			[]string{"image.jpg", "image.png", "image.webp"},
+			[]string{"image.webp", "image_1.webp", "image_2.webp"},
+		},
+		"suffix taken": {
+			[]string{"image_1.jpg", "image.jpg", "image.png"},
+			[]string{"image_1.webp", "image.webp", "image_2.webp"},
+		},
+		"no extension": {
+			[]string{"image", "image.jpg"},
+			[]string{"image.webp", "image_1.webp"},
+		},
+		"dotted name": {
+			[]string{"holidays.2024.mp4", "holidays.2024.mov"},
+			[]string{"holidays.2024.webp", "holidays.2024_1.webp"},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			outputNames := make(map[string]bool)
+
+			for index, name := range testCase.names {
+				if got := uniqueOutputName(name, outputNames); got != testCase.want[index] {
+					t.Errorf("uniqueOutputName(`%s`) = `%s`, want `%s`", name, got, testCase.want[index])
+				}
+			}
+		})
+	}
+}
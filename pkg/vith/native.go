@@ -0,0 +1,176 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// nativeMaxPixels bounds the memory of a native decoding, a file under the size threshold can still be a huge image
+const nativeMaxPixels = 50_000_000
+
+var errNativeUnsupported = errors.New("unsupported by native pipeline")
+
+var nativeFormats = map[string]bool{
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+	"webp": true,
+}
+
+func (s Service) isNativeImage(inputName string) bool {
+	if s.nativeImageSize == 0 {
+		return false
+	}
+
+	size := fileSize(inputName)
+
+	return size != 0 && size <= s.nativeImageSize
+}
+
+// nativeImageThumbnail decodes, orients, crops and resamples the image in process. Go has no WebP encoder, so ffmpeg still encodes the small raw frame:
+// that remaining call only handles scale*scale pixels, its cost is measured apart by BenchmarkEncodeWebp.
+func (s Service) nativeImageThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) (err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "native_thumbnail")
+	defer end(&err)
+
+	content, err := os.ReadFile(inputName)
+	if err != nil {
+		return fmt.Errorf("read image: %w", err)
+	}
+
+	scale := int(options.scale)
+
+	frame, err := nativeFrame(content, scale)
+	if err != nil {
+		return err
+	}
+
+	return s.encodeWebp(ctx, frame, scale, outputName, options)
+}
+
+// nativeFrame decodes the image content into the raw frame of its square thumbnail
+func nativeFrame(content []byte, scale int) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil || !nativeFormats[format] {
+		return nil, fmt.Errorf("%w: format `%s`", errNativeUnsupported, format)
+	}
+
+	if config.Width*config.Height > nativeMaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", errNativeUnsupported, config.Width, config.Height)
+	}
+
+	source, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", format, err)
+	}
+
+	var orientation int
+	if format == "jpeg" {
+		orientation = jpegOrientation(content)
+	}
+
+	return rawFrame(squareThumbnail(source, scale), orientation), nil
+}
+
+// squareThumbnail crops the center square of the image, like the ffmpeg filters, and resamples it with a Catmull-Rom kernel
+func squareThumbnail(source image.Image, scale int) *image.RGBA {
+	bounds := source.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+
+	output := image.NewRGBA(image.Rect(0, 0, scale, scale))
+	draw.CatmullRom.Scale(output, output.Bounds(), source, crop, draw.Src, nil)
+
+	return output
+}
+
+// rawFrame outputs the pixels in non-premultiplied RGBA, as expected by ffmpeg, displayed upright according to the EXIF orientation.
+// Orientation is applied on the square thumbnail, cropping the center and resampling being unaffected by it.
+func rawFrame(thumbnail *image.RGBA, orientation int) []byte {
+	side := thumbnail.Bounds().Dx()
+	last := side - 1
+
+	output := make([]byte, side*side*4)
+
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			sourceX, sourceY := x, y
+
+			switch orientation {
+			case 2:
+				sourceX = last - x
+			case 3:
+				sourceX, sourceY = last-x, last-y
+			case 4:
+				sourceY = last - y
+			case 5:
+				sourceX, sourceY = y, x
+			case 6:
+				sourceX, sourceY = y, last-x
+			case 7:
+				sourceX, sourceY = last-y, last-x
+			case 8:
+				sourceX, sourceY = last-y, x
+			}
+
+			source := thumbnail.PixOffset(sourceX, sourceY)
+			pixel := output[(y*side+x)*4 : (y*side+x)*4+4]
+
+			copy(pixel, thumbnail.Pix[source:source+4])
+
+			if alpha := uint32(pixel[3]); alpha != 0 && alpha != 0xff {
+				for i := 0; i < 3; i++ {
+					pixel[i] = uint8(uint32(pixel[i]) * 0xff / alpha)
+				}
+			}
+		}
+	}
+
+	return output
+}
+
+func (s Service) encodeWebp(ctx context.Context, frame []byte, scale int, outputName string, options thumbnailOptions) error {
+	overlay, cleanOverlay, err := s.prepareOverlay(ctx, options.overlay)
+	if err != nil {
+		return fmt.Errorf("prepare overlay: %w", err)
+	}
+	defer cleanOverlay()
+
+	ffmpegOpts := []string{"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", scale, scale), "-i", "pipe:0", "-map_metadata", "-1"}
+
+	if filters := overlay.apply(""); len(filters) != 0 {
+		ffmpegOpts = append(ffmpegOpts, "-vf", filters)
+	}
+
+	ffmpegOpts = append(ffmpegOpts, "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(options.scale), "-an", "-preset", "picture", "-y", "-f", "webp", "-frames:v", "1", outputName)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegOpts...)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdin = bytes.NewReader(frame)
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return fmt.Errorf("ffmpeg encode: %s: %w", buffer.String(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+func TestRawFrame(t *testing.T) {
+	t.Parallel()
+
+	// 2x2 thumbnail with red, green / blue, white pixels, each upright frame is read from top-left to bottom-right
+	thumbnail := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	thumbnail.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	thumbnail.Set(1, 0, color.RGBA{G: 0xff, A: 0xff})
+	thumbnail.Set(0, 1, color.RGBA{B: 0xff, A: 0xff})
+	thumbnail.Set(1, 1, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	var (
+		red   = []byte{0xff, 0, 0, 0xff}
+		green = []byte{0, 0xff, 0, 0xff}
+		blue  = []byte{0, 0, 0xff, 0xff}
+		white = []byte{0xff, 0xff, 0xff, 0xff}
+	)
+
+	cases := map[string]struct {
+		orientation int
+		want        [][]byte
+	}{
+		"none": {
+			0,
+			[][]byte{red, green, blue, white},
+		},
+		"normal": {
+			1,
+			[][]byte{red, green, blue, white},
+		},
+		"mirror horizontal": {
+			2,
+			[][]byte{green, red, white, blue},
+		},
+		"rotate 180": {
+			3,
+			[][]byte{white, blue, green, red},
+		},
+		"mirror vertical": {
+			4,
+			[][]byte{blue, white, red, green},
+		},
+		"transpose": {
+			5,
+			[][]byte{red, blue, green, white},
+		},
+		"rotate 90": {
+			6,
+			[][]byte{blue, red, white, green},
+		},
+		"transverse": {
+			7,
+			[][]byte{white, green, blue, red},
+		},
+		"rotate 270": {
+			8,
+			[][]byte{green, white, red, blue},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := rawFrame(thumbnail, testCase.orientation); !bytes.Equal(got, bytes.Join(testCase.want, nil)) {
+				t.Errorf("rawFrame() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+// benchmarkImage writes a photo sized JPEG, the native pipeline being enabled for such files
+func benchmarkImage(b *testing.B) (string, []byte) {
+	b.Helper()
+
+	source := image.NewRGBA(image.Rect(0, 0, 3000, 2000))
+	for y := 0; y < 2000; y++ {
+		for x := 0; x < 3000; x++ {
+			source.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, source, &jpeg.Options{Quality: 90}); err != nil {
+		b.Fatal(err)
+	}
+
+	inputName := filepath.Join(b.TempDir(), "input.jpg")
+	if err := os.WriteFile(inputName, buffer.Bytes(), 0o600); err != nil {
+		b.Fatal(err)
+	}
+
+	return inputName, buffer.Bytes()
+}
+
+func requireBinaries(b *testing.B, names ...string) {
+	b.Helper()
+
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			b.Skipf("%s is not installed", name)
+		}
+	}
+}
+
+func BenchmarkNativeFrame(b *testing.B) {
+	_, content := benchmarkImage(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := nativeFrame(content, SmallSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeWebp(b *testing.B) {
+	requireBinaries(b, "ffmpeg")
+
+	frame := make([]byte, SmallSize*SmallSize*4)
+	outputName := filepath.Join(b.TempDir(), "output.webp")
+	options := newThumbnailOptions(SmallSize, 0, model.Overlay{})
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := (Service{}).encodeWebp(context.Background(), frame, SmallSize, outputName, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNativeImageThumbnail(b *testing.B) {
+	requireBinaries(b, "ffmpeg")
+
+	inputName, _ := benchmarkImage(b)
+	outputName := filepath.Join(b.TempDir(), "output.webp")
+	options := newThumbnailOptions(SmallSize, 0, model.Overlay{})
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := (Service{}).nativeImageThumbnail(context.Background(), inputName, outputName, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFFmpegImageThumbnail(b *testing.B) {
+	requireBinaries(b, "ffmpeg", "ffprobe")
+
+	inputName, _ := benchmarkImage(b)
+	outputName := filepath.Join(b.TempDir(), "output.webp")
+	options := newThumbnailOptions(SmallSize, 0, model.Overlay{})
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := (Service{}).ffmpegImageThumbnail(context.Background(), inputName, outputName, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
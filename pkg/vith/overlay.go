@@ -0,0 +1,219 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	overlayMargin = 0.02
+
+	defaultOverlayPosition = "bottom-right"
+
+	// overlayNone is the watermark value that disables the default watermark for a request
+	overlayNone = "none"
+)
+
+// overlayPositions maps a position to its x and y expressions, given a frame dimension and the overlay dimension variables
+var overlayPositions = map[string]func(frameW, frameH, itemW, itemH string) string{
+	"top-left": func(frameW, frameH, _, _ string) string {
+		return fmt.Sprintf("x=%[1]s*%[3]g:y=%[2]s*%[3]g", frameW, frameH, overlayMargin)
+	},
+	"top-right": func(frameW, frameH, itemW, _ string) string {
+		return fmt.Sprintf("x=%[1]s-%[3]s-%[1]s*%[4]g:y=%[2]s*%[4]g", frameW, frameH, itemW, overlayMargin)
+	},
+	"bottom-left": func(frameW, frameH, _, itemH string) string {
+		return fmt.Sprintf("x=%[1]s*%[4]g:y=%[2]s-%[3]s-%[2]s*%[4]g", frameW, frameH, itemH, overlayMargin)
+	},
+	"bottom-right": func(frameW, frameH, itemW, itemH string) string {
+		return fmt.Sprintf("x=%[1]s-%[3]s-%[1]s*%[5]g:y=%[2]s-%[4]s-%[2]s*%[5]g", frameW, frameH, itemW, itemH, overlayMargin)
+	},
+	"center": func(frameW, frameH, itemW, itemH string) string {
+		return fmt.Sprintf("x=(%[1]s-%[3]s)/2:y=(%[2]s-%[4]s)/2", frameW, frameH, itemW, itemH)
+	},
+}
+
+var (
+	filterOptionEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+	filterGraphEscaper  = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+)
+
+type preparedOverlay struct {
+	overlay   model.Overlay
+	imageName string
+	textName  string
+	font      string
+}
+
+func (s Service) overlayOrDefault(overlay *model.Overlay) model.Overlay {
+	if overlay != nil && overlay.Disabled {
+		return model.Overlay{}
+	}
+
+	output := s.overlay
+
+	if overlay != nil && !overlay.IsZero() {
+		output.Image = overlay.Image
+		output.Text = overlay.Text
+
+		if len(overlay.Position) > 0 {
+			output.Position = overlay.Position
+		}
+
+		if overlay.Opacity > 0 {
+			output.Opacity = overlay.Opacity
+		}
+
+		if overlay.Scale > 0 {
+			output.Scale = overlay.Scale
+		}
+	}
+
+	return output
+}
+
+func parseOverlay(r *http.Request) (*model.Overlay, error) {
+	query := r.URL.Query()
+
+	if query.Get("watermark") == overlayNone {
+		return &model.Overlay{Disabled: true}, nil
+	}
+
+	overlay := model.Overlay{
+		Image:    query.Get("watermark"),
+		Text:     query.Get("watermarkText"),
+		Position: query.Get("watermarkPosition"),
+	}
+
+	if overlay.IsZero() {
+		return nil, nil
+	}
+
+	var err error
+
+	if raw := query.Get("watermarkOpacity"); len(raw) > 0 {
+		if overlay.Opacity, err = strconv.ParseFloat(raw, 64); err != nil {
+			return nil, fmt.Errorf("parse watermark opacity: %w", err)
+		}
+	}
+
+	if raw := query.Get("watermarkScale"); len(raw) > 0 {
+		if overlay.Scale, err = strconv.ParseFloat(raw, 64); err != nil {
+			return nil, fmt.Errorf("parse watermark scale: %w", err)
+		}
+	}
+
+	return &overlay, validateOverlay(overlay)
+}
+
+func validateOverlay(overlay model.Overlay) error {
+	if len(overlay.Position) > 0 {
+		if _, ok := overlayPositions[overlay.Position]; !ok {
+			return fmt.Errorf("unknown watermark position `%s`", overlay.Position)
+		}
+	}
+
+	if overlay.Opacity < 0 || overlay.Opacity > 1 {
+		return errors.New("watermark opacity must be between 0 and 1")
+	}
+
+	if overlay.Scale < 0 || overlay.Scale > 1 {
+		return errors.New("watermark scale must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// prepareOverlay writes the files needed by ffmpeg filters in the temporary folder
+func (s Service) prepareOverlay(ctx context.Context, overlay model.Overlay) (preparedOverlay, func(), error) {
+	output := preparedOverlay{
+		overlay: overlay,
+		font:    s.overlayFont,
+	}
+
+	if overlay.IsZero() {
+		return output, noopFunc, nil
+	}
+
+	if err := validateOverlay(overlay); err != nil {
+		return output, noopFunc, err
+	}
+
+	if len(overlay.Image) > 0 {
+		if !s.storage.Enabled() {
+			return output, noopFunc, errors.New("image watermark requires a storage")
+		}
+
+		reader, err := s.storage.ReadFrom(ctx, overlay.Image)
+		if err != nil {
+			return output, noopFunc, fmt.Errorf("read watermark: %w", err)
+		}
+		defer closeWithLog(ctx, reader, "prepareOverlay", overlay.Image)
+
+		output.imageName, err = s.saveUniqueFile(ctx, reader, "watermark_*"+path.Ext(overlay.Image))
+		if err != nil {
+			return output, noopFunc, fmt.Errorf("save watermark locally: %w", err)
+		}
+
+		return output, func() { cleanLocalFile(ctx, output.imageName) }, nil
+	}
+
+	var err error
+
+	output.textName, err = s.saveUniqueFile(ctx, strings.NewReader(overlay.Text), "watermark_text_*.txt")
+	if err != nil {
+		return output, noopFunc, fmt.Errorf("write watermark text: %w", err)
+	}
+
+	return output, func() { cleanLocalFile(ctx, output.textName) }, nil
+}
+
+// escapeFilterValue escapes a value for an option of a filter, then for the filtergraph that contains it
+func escapeFilterValue(value string) string {
+	return filterGraphEscaper.Replace(filterOptionEscaper.Replace(value))
+}
+
+// apply draws the overlay over the output of the given filters chain
+func (po preparedOverlay) apply(filters string) string {
+	position := overlayPositions[po.overlay.Position]
+	if position == nil {
+		position = overlayPositions[defaultOverlayPosition]
+	}
+
+	switch {
+	case len(po.imageName) > 0:
+		if len(filters) == 0 {
+			filters = "null"
+		}
+
+		return fmt.Sprintf("movie=%s,format=rgba,colorchannelmixer=aa=%g[watermark];[in]%s[base];[watermark][base]scale2ref=w='main_w*%g':h='ow/dar'[scaled][ref];[ref][scaled]overlay=%s:format=auto[out]", escapeFilterValue(po.imageName), po.overlay.Opacity, filters, po.overlay.Scale, position("main_w", "main_h", "overlay_w", "overlay_h"))
+
+	case len(po.textName) > 0:
+		drawtext := []string{
+			"drawtext=textfile=" + escapeFilterValue(po.textName),
+			"expansion=none",
+			fmt.Sprintf("fontcolor=white@%g", po.overlay.Opacity),
+			fmt.Sprintf("shadowcolor=black@%g", po.overlay.Opacity/2),
+			"shadowx=1",
+			"shadowy=1",
+			fmt.Sprintf("fontsize=h*%g", po.overlay.Scale),
+			position("w", "h", "text_w", "text_h"),
+		}
+
+		if len(po.font) > 0 {
+			drawtext = append(drawtext, "fontfile="+escapeFilterValue(po.font))
+		}
+
+		return joinFilters(filters, strings.Join(drawtext, ":"))
+
+	default:
+		return filters
+	}
+}
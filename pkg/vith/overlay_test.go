@@ -0,0 +1,160 @@
+package vith
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+func TestOverlayOrDefault(t *testing.T) {
+	t.Parallel()
+
+	service := Service{overlay: model.Overlay{Text: "vith", Position: defaultOverlayPosition, Opacity: 0.5, Scale: 0.1}}
+
+	cases := map[string]struct {
+		query string
+		want  model.Overlay
+	}{
+		"default": {
+			"",
+			model.Overlay{Text: "vith", Position: defaultOverlayPosition, Opacity: 0.5, Scale: 0.1},
+		},
+		"override": {
+			"?watermarkText=copyright&watermarkPosition=center",
+			model.Overlay{Text: "copyright", Position: "center", Opacity: 0.5, Scale: 0.1},
+		},
+		"none": {
+			"?watermark=none&watermarkText=copyright",
+			model.Overlay{},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			overlay, err := parseOverlay(httptest.NewRequest(http.MethodGet, "/"+testCase.query, nil))
+			if err != nil {
+				t.Fatalf("parseOverlay() error = %s", err)
+			}
+
+			if got := service.overlayOrDefault(overlay); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("overlayOrDefault() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPreparedOverlayApply(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		overlay preparedOverlay
+		filters string
+		want    string
+	}{
+		"none": {
+			preparedOverlay{},
+			"scale=150:-2",
+			"scale=150:-2",
+		},
+		"image": {
+			preparedOverlay{overlay: model.Overlay{Opacity: 0.5, Scale: 0.1, Position: "top-left"}, imageName: "/tmp/watermark.png"},
+			"",
+			"movie=/tmp/watermark.png,format=rgba,colorchannelmixer=aa=0.5[watermark];[in]null[base];[watermark][base]scale2ref=w='main_w*0.1':h='ow/dar'[scaled][ref];[ref][scaled]overlay=x=main_w*0.02:y=main_h*0.02:format=auto[out]",
+		},
+		"text": {
+			preparedOverlay{overlay: model.Overlay{Opacity: 0.5, Scale: 0.1, Position: "center"}, textName: "/tmp/watermark.txt"},
+			"scale=150:-2",
+			"scale=150:-2,drawtext=textfile=/tmp/watermark.txt:expansion=none:fontcolor=white@0.5:shadowcolor=black@0.25:shadowx=1:shadowy=1:fontsize=h*0.1:x=(w-text_w)/2:y=(h-text_h)/2",
+		},
+		"escaped": {
+			preparedOverlay{overlay: model.Overlay{Opacity: 0.5, Scale: 0.1, Position: "center"}, textName: "/tmp/it's:1.txt", font: `C:\fonts\a,b.ttf`},
+			"",
+			`drawtext=textfile=/tmp/it\\\'s\\:1.txt:expansion=none:fontcolor=white@0.5:shadowcolor=black@0.25:shadowx=1:shadowy=1:fontsize=h*0.1:x=(w-text_w)/2:y=(h-text_h)/2:fontfile=C\\:\\\\fonts\\\\a\,b.ttf`,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := testCase.overlay.apply(testCase.filters); got != testCase.want {
+				t.Errorf("apply() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value string
+		want  string
+	}{
+		"plain": {
+			"/tmp/vith/watermark_123.png",
+			"/tmp/vith/watermark_123.png",
+		},
+		"colon": {
+			"/tmp/a:b.png",
+			`/tmp/a\\:b.png`,
+		},
+		"quote": {
+			"/tmp/it's.png",
+			`/tmp/it\\\'s.png`,
+		},
+		"backslash": {
+			`C:\tmp`,
+			`C\\:\\\\tmp`,
+		},
+		"graph separators": {
+			"/tmp/a,b;c[d].png",
+			`/tmp/a\,b\;c\[d\].png`,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := escapeFilterValue(testCase.value); got != testCase.want {
+				t.Errorf("escapeFilterValue() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPrepareOverlayUnique(t *testing.T) {
+	t.Parallel()
+
+	service := Service{tmpFolder: t.TempDir()}
+	overlay := model.Overlay{Text: "vith", Position: defaultOverlayPosition, Opacity: 0.5, Scale: 0.1}
+
+	first, cleanFirst, err := service.prepareOverlay(context.Background(), overlay)
+	if err != nil {
+		t.Fatalf("prepareOverlay() error = %s", err)
+	}
+
+	second, cleanSecond, err := service.prepareOverlay(context.Background(), overlay)
+	if err != nil {
+		t.Fatalf("prepareOverlay() error = %s", err)
+	}
+	defer cleanSecond()
+
+	if first.textName == second.textName {
+		t.Fatalf("prepareOverlay() shares `%s` between jobs", first.textName)
+	}
+
+	cleanFirst()
+
+	if content, err := os.ReadFile(second.textName); err != nil || string(content) != overlay.Text {
+		t.Errorf("prepareOverlay() = `%s`, %v, want `%s` after the cleanup of another job", content, err, overlay.Text)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/ViBiOh/httputils/v4/pkg/httperror"
@@ -27,8 +28,8 @@ func (s Service) HandlePatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if itemType != model.TypeVideo {
-		httperror.BadRequest(ctx, w, errors.New("rename is possible for video type only"))
+	if !itemType.IsStreamable() {
+		httperror.BadRequest(ctx, w, errors.New("rename is possible for video or audio type only"))
 		return
 	}
 
@@ -59,30 +60,47 @@ func (s Service) renameStream(ctx context.Context, source, destination string) e
 	baseSourceName := path.Base(rawSourceName)
 	baseDestinationName := path.Base(rawDestinationName)
 
-	content, err := s.readFile(ctx, source)
+	files, err := s.listFiles(ctx, rawSourceName, streamFilesSuffix)
 	if err != nil {
-		return fmt.Errorf("read manifest `%s`: %w", source, err)
+		return fmt.Errorf("list hls files for `%s`: %w", rawSourceName, err)
 	}
 
-	segments, err := s.listFiles(ctx, rawSourceName+`.*\.ts`)
-	if err != nil {
-		return fmt.Errorf("list hls segments for `%s`: %w", rawSourceName, err)
-	}
+	var manifests []string
+
+	for _, file := range files {
+		if filepath.Ext(file) != hlsExtension {
+			continue
+		}
+
+		manifests = append(manifests, file)
+
+		content, err := s.readFile(ctx, file)
+		if err != nil {
+			return fmt.Errorf("read manifest `%s`: %w", file, err)
+		}
 
-	if err := s.writeFile(ctx, destination, bytes.ReplaceAll(content, []byte(baseSourceName), []byte(baseDestinationName))); err != nil {
-		return fmt.Errorf("write destination file `%s`: %w", destination, err)
+		newName := rawDestinationName + strings.TrimPrefix(file, rawSourceName)
+		if err := s.writeFile(ctx, newName, bytes.ReplaceAll(content, []byte(baseSourceName), []byte(baseDestinationName))); err != nil {
+			return fmt.Errorf("write destination file `%s`: %w", newName, err)
+		}
 	}
 
-	for _, file := range segments {
+	for _, file := range files {
+		if filepath.Ext(file) == hlsExtension {
+			continue
+		}
+
 		newName := rawDestinationName + strings.TrimPrefix(file, rawSourceName)
 		if err := s.storage.Rename(ctx, file, newName); err != nil {
 			return fmt.Errorf("rename `%s` to `%s`: %w", file, newName, err)
 		}
 	}
 
-	if err := s.storage.RemoveAll(ctx, source); err != nil {
-		return fmt.Errorf("delete `%s`: %w", source, err)
+	for _, manifest := range manifests {
+		if err := s.storage.RemoveAll(ctx, manifest); err != nil {
+			return fmt.Errorf("delete `%s`: %w", manifest, err)
+		}
 	}
 
-	return nil
+	return s.renameStreamKeys(ctx, source, destination)
 }
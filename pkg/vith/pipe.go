@@ -0,0 +1,194 @@
+package vith
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+// pipeHeaderSize is the part of the body peeked to detect the format and the EXIF orientation, that are usually at its very beginning
+const pipeHeaderSize = 64 << 10
+
+// errResponseStarted occurs when ffmpeg fails after having written a part of the thumbnail in the response
+var errResponseStarted = errors.New("response already started")
+
+// pipeDemuxers maps the image formats that ffmpeg decodes without seeking to their demuxer, others need a local file
+var pipeDemuxers = map[string]string{
+	"image/jpeg": "jpeg_pipe",
+	"image/png":  "png_pipe",
+	"image/gif":  "gif",
+	"image/webp": "webp_pipe",
+	"image/bmp":  "bmp_pipe",
+}
+
+type pipeImage struct {
+	demuxer     string
+	orientation int
+}
+
+// sniffPipeImage peeks the body to find if it can be piped to ffmpeg, the returned body has to be read instead of the given one
+func sniffPipeImage(body io.ReadCloser) (io.ReadCloser, pipeImage, bool) {
+	reader := bufio.NewReaderSize(body, pipeHeaderSize)
+
+	// an error means a body shorter than the header or a failing one, that will be reported when read
+	header, _ := reader.Peek(pipeHeaderSize)
+
+	peeked := struct {
+		io.Reader
+		io.Closer
+	}{reader, body}
+
+	demuxer, ok := pipeDemuxers[http.DetectContentType(header)]
+	if !ok {
+		return peeked, pipeImage{}, false
+	}
+
+	return peeked, pipeImage{demuxer: demuxer, orientation: jpegOrientation(header)}, true
+}
+
+// pipeThumbnail streams the body to ffmpeg and its output to the response, without any local file
+func (s Service) pipeThumbnail(ctx context.Context, w http.ResponseWriter, body io.Reader, image pipeImage, options thumbnailOptions) (err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_pipe_thumbnail")
+	defer end(&err)
+
+	if err = s.requireFeature("thumbnail"); err != nil {
+		return err
+	}
+
+	overlay, cleanOverlay, err := s.prepareOverlay(ctx, options.overlay)
+	if err != nil {
+		return fmt.Errorf("prepare overlay: %w", err)
+	}
+	defer cleanOverlay()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hwaccel", "auto", "-noautorotate", "-f", image.demuxer, "-i", "pipe:0", "-map_metadata", "-1", "-vf", overlay.apply(thumbnailFilters(mediaInfo{Orientation: image.orientation}, options.scale)), "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(options.scale), "-an", "-preset", "picture", "-f", "webp", "-frames:v", "1", "pipe:1")
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+
+	output := &pipeResponse{writer: w}
+
+	cmd.Stdin = body
+	cmd.Stdout = output
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		err = fmt.Errorf("ffmpeg pipe image: %s: %w", buffer.String(), err)
+
+		if output.written {
+			return fmt.Errorf("%w: %w", errResponseStarted, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// pipeResponse sets the headers of the thumbnail on first write, so a failure of ffmpeg before any output can still be answered with an error
+type pipeResponse struct {
+	writer  http.ResponseWriter
+	written bool
+}
+
+func (pr *pipeResponse) Write(content []byte) (int, error) {
+	if !pr.written {
+		pr.written = true
+		pr.writer.Header().Set("Content-Type", "image/webp")
+	}
+
+	return pr.writer.Write(content)
+}
+
+// handlePipeError answers the error unless the thumbnail has already been partially sent, in which case it can only be logged
+func handlePipeError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, errResponseStarted) {
+		slog.LogAttrs(ctx, slog.LevelError, "pipe thumbnail", slog.Any("error", err))
+		return
+	}
+
+	handleJobError(ctx, w, err)
+}
+
+// jpegOrientation reads the EXIF orientation from the APP1 segment of a JPEG header, 0 if there is none
+func jpegOrientation(header []byte) int {
+	if !bytes.HasPrefix(header, []byte{0xFF, 0xD8}) {
+		return 0
+	}
+
+	for offset := 2; offset+4 <= len(header); {
+		if header[offset] != 0xFF {
+			return 0
+		}
+
+		marker := header[offset+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan or end of image, metadata segments are all before
+			return 0
+		}
+
+		end := offset + 2 + int(binary.BigEndian.Uint16(header[offset+2:]))
+		if end > len(header) || end < offset+4 {
+			return 0
+		}
+
+		if marker == 0xE1 {
+			if orientation := exifOrientation(header[offset+4 : end]); orientation != 0 {
+				return orientation
+			}
+		}
+
+		offset = end
+	}
+
+	return 0
+}
+
+// exifOrientation reads the orientation tag of the first IFD of an EXIF segment
+func exifOrientation(segment []byte) int {
+	tiff, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	for entry := ifd + 2; entry < ifd+2+int(order.Uint16(tiff[ifd:]))*12 && entry+12 <= len(tiff); entry += 12 {
+		if order.Uint16(tiff[entry:]) != 0x0112 {
+			continue
+		}
+
+		if orientation := int(order.Uint16(tiff[entry+8:])); orientation > 1 && orientation <= 8 {
+			return orientation
+		}
+
+		return 0
+	}
+
+	return 0
+}
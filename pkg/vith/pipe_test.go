@@ -0,0 +1,278 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/iotest"
+)
+
+// jpegHeader builds the start of a JPEG with an APP0 segment and an APP1 segment holding the given EXIF orientation, none if 0
+func jpegHeader(order binary.AppendByteOrder, orientation uint16) []byte {
+	header := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00}
+
+	if orientation != 0 {
+		tiff := []byte("MM\x00\x2A")
+		if order == binary.LittleEndian {
+			tiff = []byte("II\x2A\x00")
+		}
+
+		tiff = order.AppendUint32(tiff, 8)
+		tiff = order.AppendUint16(tiff, 2)
+
+		// an unrelated tag before the orientation one: ImageWidth
+		tiff = order.AppendUint16(tiff, 0x0100)
+		tiff = order.AppendUint16(tiff, 3)
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint32(tiff, 4000)
+
+		tiff = order.AppendUint16(tiff, 0x0112)
+		tiff = order.AppendUint16(tiff, 3)
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint16(tiff, orientation)
+		tiff = order.AppendUint16(tiff, 0)
+
+		tiff = order.AppendUint32(tiff, 0)
+
+		segment := append([]byte("Exif\x00\x00"), tiff...)
+
+		header = append(header, 0xFF, 0xE1)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(segment)+2))
+		header = append(header, segment...)
+	}
+
+	return append(header, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	t.Parallel()
+
+	type jpegCase struct {
+		header []byte
+		want   int
+	}
+
+	cases := map[string]jpegCase{
+		"not a jpeg": {
+			[]byte("\x89PNG\r\n\x1a\n"),
+			0,
+		},
+		"no exif": {
+			jpegHeader(binary.BigEndian, 0),
+			0,
+		},
+		"truncated": {
+			jpegHeader(binary.BigEndian, 6)[:30],
+			0,
+		},
+		"exif segment after scan": {
+			append([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, jpegHeader(binary.BigEndian, 6)[2:]...),
+			0,
+		},
+		"invalid orientation": {
+			jpegHeader(binary.BigEndian, 9),
+			0,
+		},
+	}
+
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		// orientation 1 is already upright, it is reported as none
+		want := int(orientation)
+		if orientation == 1 {
+			want = 0
+		}
+
+		cases[fmt.Sprintf("big endian %d", orientation)] = jpegCase{jpegHeader(binary.BigEndian, orientation), want}
+		cases[fmt.Sprintf("little endian %d", orientation)] = jpegCase{jpegHeader(binary.LittleEndian, orientation), want}
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := jpegOrientation(testCase.header); got != testCase.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSniffPipeImage(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content     []byte
+		want        bool
+		demuxer     string
+		orientation int
+	}{
+		"jpeg": {
+			append(jpegHeader(binary.LittleEndian, 6), bytes.Repeat([]byte{0x42}, 1024)...),
+			true,
+			"jpeg_pipe",
+			6,
+		},
+		"jpeg larger than header": {
+			append(jpegHeader(binary.BigEndian, 8), bytes.Repeat([]byte{0x42}, pipeHeaderSize*2)...),
+			true,
+			"jpeg_pipe",
+			8,
+		},
+		"png": {
+			append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), bytes.Repeat([]byte{0}, 64)...),
+			true,
+			"png_pipe",
+			0,
+		},
+		"webp": {
+			append([]byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00"), bytes.Repeat([]byte{0}, 64)...),
+			true,
+			"webp_pipe",
+			0,
+		},
+		"gif": {
+			append([]byte("GIF89a\x01\x00\x01\x00"), bytes.Repeat([]byte{0}, 16)...),
+			true,
+			"gif",
+			0,
+		},
+		"heic needs a local file": {
+			append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), bytes.Repeat([]byte{0}, 64)...),
+			false,
+			"",
+			0,
+		},
+		"not an image": {
+			[]byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"),
+			false,
+			"",
+			0,
+		},
+		"empty": {
+			nil,
+			false,
+			"",
+			0,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			// one byte per read, so the header is split across many reads of the body
+			body, image, ok := sniffPipeImage(io.NopCloser(iotest.OneByteReader(bytes.NewReader(testCase.content))))
+
+			if ok != testCase.want || image.demuxer != testCase.demuxer || image.orientation != testCase.orientation {
+				t.Errorf("sniffPipeImage() = %+v, %t, want {demuxer:%s orientation:%d}, %t", image, ok, testCase.demuxer, testCase.orientation, testCase.want)
+			}
+
+			// piped or saved on disk, the returned body has to give the whole content
+			content, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(content, testCase.content) {
+				t.Errorf("sniffPipeImage() body has %d bytes, want %d", len(content), len(testCase.content))
+			}
+		})
+	}
+}
+
+func TestSniffPipeImageFailingBody(t *testing.T) {
+	t.Parallel()
+
+	errBody := errors.New("connection reset")
+
+	body, _, ok := sniffPipeImage(io.NopCloser(iotest.ErrReader(errBody)))
+	if ok {
+		t.Error("sniffPipeImage() = true for a failing body")
+	}
+
+	if _, err := io.ReadAll(body); !errors.Is(err, errBody) {
+		t.Errorf("body error = %v, want %v", err, errBody)
+	}
+}
+
+func TestHandlePipeError(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err      error
+		written  bool
+		wantCode int
+	}{
+		"response started": {
+			fmt.Errorf("%w: %w", errResponseStarted, errors.New("ffmpeg pipe image: exit status 1")),
+			true,
+			http.StatusOK,
+		},
+		"unavailable": {
+			fmt.Errorf("%w: thumbnail needs libwebp", ErrUnavailable),
+			false,
+			http.StatusNotImplemented,
+		},
+		"ffmpeg failure": {
+			errors.New("ffmpeg pipe image: exit status 1"),
+			false,
+			http.StatusInternalServerError,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			writer := httptest.NewRecorder()
+
+			if testCase.written {
+				output := &pipeResponse{writer: writer}
+				if _, err := output.Write([]byte("RIFF")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			handlePipeError(context.Background(), writer, testCase.err)
+
+			if writer.Code != testCase.wantCode {
+				t.Errorf("handlePipeError() = %d, want %d", writer.Code, testCase.wantCode)
+			}
+
+			if testCase.written && writer.Body.String() != "RIFF" {
+				t.Errorf("handlePipeError() wrote `%s` after the thumbnail", writer.Body.String())
+			}
+		})
+	}
+}
+
+func TestPipeResponse(t *testing.T) {
+	t.Parallel()
+
+	writer := httptest.NewRecorder()
+	output := &pipeResponse{writer: writer}
+
+	if output.written || len(writer.Header().Get("Content-Type")) != 0 {
+		t.Fatal("pipeResponse set headers before any write")
+	}
+
+	for _, chunk := range []string{"RIFF", "WEBP"} {
+		if _, err := output.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := writer.Header().Get("Content-Type"); got != "image/webp" || !output.written {
+		t.Errorf("Content-Type = `%s`, written = %t, want image/webp, true", got, output.written)
+	}
+
+	if got := writer.Body.String(); got != "RIFFWEBP" {
+		t.Errorf("body = `%s`, want `RIFFWEBP`", got)
+	}
+}
@@ -0,0 +1,236 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	placeholderSize = 32
+	blurHashX       = 4
+	blurHashY       = 3
+	paletteSize     = 5
+
+	base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// placeholder computes the BlurHash and colours of the first frame of a thumbnail
+func (s Service) placeholder(ctx context.Context, thumbnailName string) (placeholder model.Placeholder, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "placeholder")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", thumbnailName, "-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:%d:flags=area,format=rgb24", placeholderSize, placeholderSize), "-f", "rawvideo", "-pix_fmt", "rgb24", "pipe:1")
+
+	output := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(output)
+
+	errBuffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(errBuffer)
+
+	output.Reset()
+	errBuffer.Reset()
+	cmd.Stdout = output
+	cmd.Stderr = errBuffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return placeholder, fmt.Errorf("ffmpeg placeholder: %s: %w", errBuffer.String(), err)
+	}
+
+	pixels := output.Bytes()
+	if len(pixels) < placeholderSize*placeholderSize*3 {
+		return placeholder, errors.New("no frame decoded")
+	}
+
+	pixels = pixels[:placeholderSize*placeholderSize*3]
+
+	placeholder.BlurHash = blurHash(pixels, placeholderSize, placeholderSize, blurHashX, blurHashY)
+	placeholder.Average = averageColor(pixels)
+	placeholder.Palette = dominantColors(pixels, paletteSize)
+
+	return placeholder, nil
+}
+
+func setPlaceholderHeaders(header http.Header, placeholder *model.Placeholder) {
+	if placeholder == nil {
+		return
+	}
+
+	header.Set("X-Vith-BlurHash", placeholder.BlurHash)
+	header.Set("X-Vith-Average-Color", placeholder.Average)
+	header.Set("X-Vith-Palette", strings.Join(placeholder.Palette, ","))
+}
+
+// blurHash encodes rgb24 pixels following https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func blurHash(pixels []byte, width, height, xComponents, yComponents int) string {
+	factors := make([][3]float64, 0, xComponents*yComponents)
+
+	for j := range yComponents {
+		for i := range xComponents {
+			var factor [3]float64
+
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			for y := range height {
+				for x := range width {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					offset := 3 * (y*width + x)
+
+					for channel := range 3 {
+						factor[channel] += basis * srgbToLinear(pixels[offset+channel])
+					}
+				}
+			}
+
+			for channel := range 3 {
+				factor[channel] /= float64(width * height)
+			}
+
+			factors = append(factors, factor)
+		}
+	}
+
+	var output strings.Builder
+
+	output.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximumValue := 1.0
+
+	if len(factors) > 1 {
+		var actualMaximum float64
+		for _, factor := range factors[1:] {
+			for _, value := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(value))
+			}
+		}
+
+		quantisedMaximum := max(0, min(82, int(math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+
+		output.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		output.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	output.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		var value int
+		for _, channel := range factor {
+			value = value*19 + max(0, min(18, int(math.Floor(signPow(channel/maximumValue, 0.5)*9+9.5))))
+		}
+
+		output.WriteString(encode83(value, 2))
+	}
+
+	return output.String()
+}
+
+func encode83(value, length int) string {
+	output := make([]byte, length)
+
+	for i := range length {
+		output[length-1-i] = base83Characters[value%83]
+		value /= 83
+	}
+
+	return string(output)
+}
+
+func srgbToLinear(value byte) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+func averageColor(pixels []byte) string {
+	var sum [3]int
+
+	for offset := 0; offset+2 < len(pixels); offset += 3 {
+		for channel := range 3 {
+			sum[channel] += int(pixels[offset+channel])
+		}
+	}
+
+	count := len(pixels) / 3
+
+	return hexColor(sum[0]/count, sum[1]/count, sum[2]/count)
+}
+
+// dominantColors quantizes pixels on 4 bits per channel and returns the average colour of the most populated buckets
+func dominantColors(pixels []byte, count int) []string {
+	type bucket struct {
+		sum   [3]int
+		count int
+	}
+
+	buckets := make(map[int]*bucket)
+
+	for offset := 0; offset+2 < len(pixels); offset += 3 {
+		key := int(pixels[offset]>>4)<<8 | int(pixels[offset+1]>>4)<<4 | int(pixels[offset+2]>>4)
+
+		item, ok := buckets[key]
+		if !ok {
+			item = &bucket{}
+			buckets[key] = item
+		}
+
+		for channel := range 3 {
+			item.sum[channel] += int(pixels[offset+channel])
+		}
+		item.count++
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, item := range buckets {
+		sorted = append(sorted, item)
+	}
+
+	slices.SortFunc(sorted, func(a, b *bucket) int {
+		if a.count != b.count {
+			return b.count - a.count
+		}
+
+		return (b.sum[0] + b.sum[1] + b.sum[2]) - (a.sum[0] + a.sum[1] + a.sum[2])
+	})
+
+	output := make([]string, 0, count)
+	for _, item := range sorted[:min(count, len(sorted))] {
+		output = append(output, hexColor(item.sum[0]/item.count, item.sum[1]/item.count, item.sum[2]/item.count))
+	}
+
+	return output
+}
+
+func hexColor(red, green, blue int) string {
+	return fmt.Sprintf("#%02x%02x%02x", red, green, blue)
+}
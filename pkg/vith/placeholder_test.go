@@ -0,0 +1,125 @@
+package vith
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	t.Parallel()
+
+	var gradient []byte
+	for y := range 6 {
+		for x := range 8 {
+			gradient = append(gradient, byte(x*32), byte(y*40), 128)
+		}
+	}
+
+	cases := map[string]struct {
+		pixels      []byte
+		width       int
+		height      int
+		xComponents int
+		yComponents int
+		want        string
+	}{
+		"black pixel": {
+			[]byte{0, 0, 0},
+			1,
+			1,
+			1,
+			1,
+			"000000",
+		},
+		"white dc only": {
+			bytes.Repeat([]byte{255, 255, 255}, 4),
+			2,
+			2,
+			1,
+			1,
+			"00TSUA",
+		},
+		"solid": {
+			bytes.Repeat([]byte{200, 100, 50}, 12),
+			4,
+			3,
+			4,
+			3,
+			"L$M|T9^4fQ^4}XxFfQxFfQfQfQfQ",
+		},
+		"gradient": {
+			gradient,
+			8,
+			6,
+			4,
+			3,
+			"LjF=ad3Ba|xuzONLfQnTeqf7fQf7",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got := blurHash(testCase.pixels, testCase.width, testCase.height, testCase.xComponents, testCase.yComponents)
+			if got != testCase.want {
+				t.Errorf("blurHash() = `%s`, want `%s`", got, testCase.want)
+			}
+
+			if wantLength := 4 + 2*testCase.xComponents*testCase.yComponents; len(got) != wantLength {
+				t.Errorf("len(blurHash()) = %d, want %d", len(got), wantLength)
+			}
+		})
+	}
+}
+
+func TestEncode83(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value  int
+		length int
+		want   string
+	}{
+		"zero": {
+			0,
+			1,
+			"0",
+		},
+		"last digit": {
+			82,
+			1,
+			"~",
+		},
+		"carry": {
+			83,
+			2,
+			"10",
+		},
+		"largest of two": {
+			83*83 - 1,
+			2,
+			"~~",
+		},
+		"padded": {
+			1,
+			4,
+			"0001",
+		},
+		"white": {
+			0xFFFFFF,
+			4,
+			"TSUA",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := encode83(testCase.value, testCase.length); got != testCase.want {
+				t.Errorf("encode83() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package vith
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
-	"strconv"
+	"os"
 	"time"
 
 	"github.com/ViBiOh/httputils/v4/pkg/httperror"
@@ -16,6 +20,11 @@ const defaultScale uint64 = 150
 func (s Service) HandlePost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	if isMultipart(r) {
+		s.handleMultipartPost(w, r)
+		return
+	}
+
 	itemType, err := model.ParseItemType(r.URL.Query().Get("type"))
 	if err != nil {
 		httperror.BadRequest(ctx, w, err)
@@ -23,30 +32,45 @@ func (s Service) HandlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scale := defaultScale
-	if rawScale := r.URL.Query().Get("scale"); len(rawScale) > 0 {
-		scale, err = strconv.ParseUint(r.URL.Query().Get("scale"), 10, 64)
-		if err != nil {
-			httperror.BadRequest(ctx, w, fmt.Errorf("parse scale: %w", err))
-			s.increaseMetric(r.Context(), "http", "thumbnail", "", "invalid")
+	options, err := s.parseThumbnailOptions(r)
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(r.Context(), "http", "thumbnail", "", "invalid")
+		return
+	}
+
+	ctx, done := s.startJob(ctx, "thumbnail", itemType)
+	defer done()
+
+	if itemType == model.TypeImage && s.pipeImages {
+		body, image, ok := sniffPipeImage(r.Body)
+		if ok {
+			if err = s.pipeThumbnail(ctx, w, body, image, options); err != nil {
+				handlePipeError(ctx, w, err)
+				s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "error")
+				return
+			}
+
+			s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "piped")
 			return
 		}
+
+		r.Body = body
 	}
 
-	switch itemType {
-	case model.TypeImage, model.TypeVideo:
-		var inputName string
-		inputName, err = s.saveFileLocally(ctx, r.Body, time.Now().String())
-		defer cleanLocalFile(ctx, inputName)
+	release, err := s.reserveBody(ctx, w, r)
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "rejected")
+		return
+	}
+	defer release()
 
-		if err == nil {
-			outputName := s.getLocalFilename(fmt.Sprintf("output_%s", inputName))
-			defer cleanLocalFile(ctx, outputName)
+	state := "success"
 
-			if err = s.getThumbnailGenerator(itemType)(r.Context(), inputName, outputName, scale); err == nil {
-				err = copyLocalFile(ctx, outputName, w)
-			}
-		}
+	switch itemType {
+	case model.TypeImage, model.TypeVideo, model.TypeAudio, model.TypeDocument:
+		state, err = s.bodyThumbnail(ctx, w, r, r.Body, itemType, options)
 
 	default:
 		httperror.BadRequest(ctx, w, errors.New("unhandled item type"))
@@ -54,10 +78,127 @@ func (s Service) HandlePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		httperror.InternalServerError(ctx, w, err)
+		handleJobError(ctx, w, err)
 		s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "error")
 		return
 	}
 
-	s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), "success")
+	s.increaseMetric(r.Context(), "http", "thumbnail", itemType.String(), state)
+}
+
+// bodyThumbnail generates the thumbnail of the body and writes it to the response, unless the client or the cache already has it
+func (s Service) bodyThumbnail(ctx context.Context, w http.ResponseWriter, r *http.Request, body io.ReadCloser, itemType model.ItemType, options thumbnailOptions) (string, error) {
+	inputHash := sha256.New()
+
+	inputName, err := s.saveFileLocally(ctx, struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, inputHash), body}, time.Now().String())
+	defer cleanLocalFile(ctx, inputName)
+
+	if err != nil {
+		return "", err
+	}
+
+	key, err := s.cacheKey(ctx, inputHash, itemType, options)
+	if err != nil {
+		return "", err
+	}
+	etag := `"` + key + `"`
+
+	w.Header().Set("ETag", etag)
+
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return "not_modified", nil
+	}
+
+	reader, metadata, err := s.keyedThumbnail(ctx, inputName, key, itemType, options)
+	if err != nil {
+		return "", err
+	}
+	defer closeWithLog(ctx, reader, "bodyThumbnail", key)
+
+	setPlaceholderHeaders(w.Header(), metadata.Placeholder)
+
+	_, err = io.Copy(w, reader)
+
+	return "success", err
+}
+
+// keyedThumbnail returns the cached thumbnail of the key, or generates it and caches it
+func (s Service) keyedThumbnail(ctx context.Context, inputName, key string, itemType model.ItemType, options thumbnailOptions) (io.ReadCloser, cacheMetadata, error) {
+	var metadata cacheMetadata
+
+	if s.cache != nil {
+		reader, metadata, err := s.cache.get(ctx, key)
+		if err == nil {
+			s.recordCache(ctx, "hit")
+			return reader, metadata, nil
+		}
+
+		if !errors.Is(err, errCacheMiss) {
+			slog.LogAttrs(ctx, slog.LevelError, "get cached thumbnail", slog.String("key", key), slog.Any("error", err))
+		}
+
+		s.recordCache(ctx, "miss")
+	}
+
+	outputName := s.getLocalFilename(fmt.Sprintf("output_%s", inputName))
+
+	if err := s.getThumbnailGenerator(itemType)(ctx, inputName, outputName, options); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return nil, metadata, err
+	}
+
+	s.recordInputSize(ctx, inputName)
+	s.recordOutputSize(ctx, outputName)
+
+	if placeholder, err := s.placeholder(ctx, outputName); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "placeholder", slog.Any("error", err))
+	} else {
+		metadata.Placeholder = &placeholder
+	}
+
+	if s.cache != nil {
+		if err := s.cache.set(ctx, key, outputName, metadata); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "cache thumbnail", slog.String("key", key), slog.Any("error", err))
+		}
+	}
+
+	file, err := os.Open(outputName)
+	if err != nil {
+		cleanLocalFile(ctx, outputName)
+		return nil, metadata, fmt.Errorf("open thumbnail: %w", err)
+	}
+
+	return removeOnClose{file}, metadata, nil
+}
+
+// removeOnClose deletes the local file once read
+type removeOnClose struct {
+	*os.File
+}
+
+func (roc removeOnClose) Close() error {
+	return errors.Join(roc.File.Close(), os.Remove(roc.Name()))
+}
+
+func (s Service) parseThumbnailOptions(r *http.Request) (thumbnailOptions, error) {
+	scale, err := parseUintParam(r, "scale")
+	if err != nil {
+		return thumbnailOptions{}, err
+	}
+
+	page, err := parseUintParam(r, "page")
+	if err != nil {
+		return thumbnailOptions{}, err
+	}
+
+	overlay, err := parseOverlay(r)
+	if err != nil {
+		return thumbnailOptions{}, err
+	}
+
+	return newThumbnailOptions(scale, page, s.overlayOrDefault(overlay)), nil
 }
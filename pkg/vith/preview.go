@@ -0,0 +1,300 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	mp4Format  = "mp4"
+	webmFormat = "webm"
+
+	defaultPreviewDuration = 5.0
+	defaultPreviewFps      = 15
+	maxPreviewSegments     = 10
+)
+
+type previewOptions struct {
+	format   string
+	duration float64
+	fps      uint64
+	scale    uint64
+	segments uint64
+}
+
+func newPreviewOptions(format string, duration float64, fps, scale, segments uint64) (previewOptions, error) {
+	format = strings.TrimPrefix(strings.ToLower(format), ".")
+	if len(format) == 0 {
+		format = mp4Format
+	}
+
+	if format != mp4Format && format != webmFormat {
+		return previewOptions{}, fmt.Errorf("unhandled preview format `%s`", format)
+	}
+
+	if duration <= 0 {
+		duration = defaultPreviewDuration
+	}
+
+	if fps == 0 {
+		fps = defaultPreviewFps
+	}
+
+	if scale == 0 {
+		scale = SmallSize
+	}
+
+	if segments == 0 {
+		segments = 1
+	} else if segments > maxPreviewSegments {
+		return previewOptions{}, fmt.Errorf("segments must be lower or equal to %d", maxPreviewSegments)
+	}
+
+	return previewOptions{
+		format:   format,
+		duration: duration,
+		fps:      fps,
+		scale:    scale,
+		segments: segments,
+	}, nil
+}
+
+func (po previewOptions) contentType() string {
+	return "video/" + po.format
+}
+
+func (s Service) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	options, err := parsePreviewOptions(r)
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "preview", model.TypeVideo.String(), "invalid")
+		return
+	}
+
+	release, err := s.reserveBody(ctx, w, r)
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "preview", model.TypeVideo.String(), "rejected")
+		return
+	}
+	defer release()
+
+	inputName, err := s.saveFileLocally(ctx, r.Body, time.Now().String())
+	defer cleanLocalFile(ctx, inputName)
+
+	if err == nil {
+		outputName := s.getLocalFilename(fmt.Sprintf("output_%s", inputName)) + "." + options.format
+		defer cleanLocalFile(ctx, outputName)
+
+		if err = s.videoPreview(ctx, inputName, outputName, options); err == nil {
+			w.Header().Set("Content-Type", options.contentType())
+			err = copyLocalFile(ctx, outputName, w)
+		}
+	}
+
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "preview", model.TypeVideo.String(), "error")
+		return
+	}
+
+	s.increaseMetric(ctx, "http", "preview", model.TypeVideo.String(), "success")
+}
+
+func parsePreviewOptions(r *http.Request) (previewOptions, error) {
+	query := r.URL.Query()
+
+	var duration float64
+	var fps, scale, segments uint64
+	var err error
+
+	if raw := query.Get("duration"); len(raw) > 0 {
+		if duration, err = strconv.ParseFloat(raw, 64); err != nil {
+			return previewOptions{}, fmt.Errorf("parse duration: %w", err)
+		}
+	}
+
+	for name, value := range map[string]*uint64{"fps": &fps, "scale": &scale, "segments": &segments} {
+		if *value, err = parseUintParam(r, name); err != nil {
+			return previewOptions{}, err
+		}
+	}
+
+	return newPreviewOptions(query.Get("format"), duration, fps, scale, segments)
+}
+
+func (s Service) AmqpPreviewHandler(ctx context.Context, message amqp.Delivery) error {
+	if !s.storage.Enabled() {
+		return errors.New("vith has no direct access to filesystem")
+	}
+
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "amqp")
+	defer end(&err)
+
+	var req model.Request
+	if err = json.Unmarshal(message.Body, &req); err != nil {
+		s.increaseMetric(ctx, "amqp", "preview", "", "invalid")
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	if req.ItemType != model.TypeVideo {
+		s.increaseMetric(ctx, "amqp", "preview", req.ItemType.String(), "forbidden")
+		return errors.New("preview are possible for video type only")
+	}
+
+	options, err := newPreviewOptions(filepath.Ext(req.Output), req.Duration, req.Fps, req.Scale, req.Segments)
+	if err != nil {
+		s.increaseMetric(ctx, "amqp", "preview", req.ItemType.String(), "invalid")
+		return fmt.Errorf("preview options: %w", err)
+	}
+
+	if err = s.storagePreview(ctx, req.Input, req.Output, options); err != nil {
+		s.increaseMetric(ctx, "amqp", "preview", req.ItemType.String(), "error")
+		return err
+	}
+
+	if err = s.amqpClient.PublishJSON(ctx, req, s.amqpExchange, s.amqpPreviewKey); err != nil {
+		return fmt.Errorf("publish amqp message: %w", err)
+	}
+
+	s.increaseMetric(ctx, "amqp", "preview", req.ItemType.String(), "success")
+	return nil
+}
+
+func (s Service) storagePreview(ctx context.Context, input, output string, options previewOptions) error {
+	if err := s.storage.Mkdir(ctx, path.Dir(output), absto.DirectoryPerm); err != nil {
+		return fmt.Errorf("create directory for output: %w", err)
+	}
+
+	inputName, finalizeInput, err := s.getInputName(ctx, input)
+	if err != nil {
+		return fmt.Errorf("get input name: %w", err)
+	}
+	defer finalizeInput()
+
+	outputName, finalizeOutput := s.getOutputName(ctx, output)
+
+	return errors.Join(s.videoPreview(ctx, inputName, outputName, options), finalizeOutput())
+}
+
+func (s Service) videoPreview(ctx context.Context, inputName, outputName string, options previewOptions) error {
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_preview")
+	defer end(&err)
+
+	ctx, done := s.startJob(ctx, "preview", model.TypeVideo)
+	defer done()
+
+	if err = s.requireFeature("preview_" + options.format); err != nil {
+		return err
+	}
+
+	info, infoErr := s.getMediaInfo(ctx, inputName)
+	if infoErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video info", slog.String("input", inputName), slog.Any("error", infoErr))
+	}
+
+	_, duration, durationErr := s.getVideoDetails(ctx, inputName)
+	if durationErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get container duration", slog.String("input", inputName), slog.Any("error", durationErr))
+	}
+
+	segmentDuration := options.duration / float64(options.segments)
+
+	var ffmpegOpts []string
+
+	for _, start := range previewStartPoints(duration, segmentDuration, options.segments) {
+		ffmpegOpts = append(ffmpegOpts, "-noautorotate", "-ss", fmt.Sprintf("%.3f", start), "-t", fmt.Sprintf("%.3f", segmentDuration), "-i", inputName)
+	}
+
+	ffmpegOpts = append(ffmpegOpts, "-filter_complex", previewFilterGraph(info, options), "-map", "[preview]", "-map_metadata", "-1", "-an")
+	ffmpegOpts = append(ffmpegOpts, previewCodecOptions(options.format)...)
+	ffmpegOpts = append(ffmpegOpts, "-y", "-f", options.format, outputName)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegOpts...)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return fmt.Errorf("ffmpeg preview: %s: %w", buffer.String(), err)
+	}
+
+	s.recordInputSize(ctx, inputName)
+	s.recordOutputSize(ctx, outputName)
+
+	return nil
+}
+
+// previewFilterGraph scales each input, one per segment, and concatenates them in the `preview` output
+func previewFilterGraph(info mediaInfo, options previewOptions) string {
+	filters := joinFilters(info.toneMapping(), thumbnailFilters(info, options.scale), fmt.Sprintf("fps=%d,setsar=1", options.fps))
+
+	var filterComplex strings.Builder
+
+	for index := range options.segments {
+		fmt.Fprintf(&filterComplex, "[%d:v:0]%s[v%d];", index, filters, index)
+	}
+
+	for index := range options.segments {
+		fmt.Fprintf(&filterComplex, "[v%d]", index)
+	}
+
+	fmt.Fprintf(&filterComplex, "concat=n=%d:v=1:a=0[preview]", options.segments)
+
+	return filterComplex.String()
+}
+
+func previewStartPoints(duration, segmentDuration float64, segments uint64) []float64 {
+	output := make([]float64, segments)
+
+	if duration <= 0 {
+		return output
+	}
+
+	for index := range output {
+		start := duration*float64(index+1)/float64(segments+1) - segmentDuration/2
+		if start < 0 {
+			start = 0
+		}
+
+		output[index] = start
+	}
+
+	return output
+}
+
+func previewCodecOptions(format string) []string {
+	if format == webmFormat {
+		return []string{"-codec:v", "libvpx-vp9", "-b:v", "0", "-crf", "40", "-deadline", "realtime", "-cpu-used", "8", "-pix_fmt", "yuv420p"}
+	}
+
+	return []string{"-codec:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p", "-movflags", "+faststart"}
+}
@@ -0,0 +1,274 @@
+package vith
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewPreviewOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		format   string
+		duration float64
+		fps      uint64
+		scale    uint64
+		segments uint64
+		want     previewOptions
+		wantErr  bool
+	}{
+		"defaults": {
+			"",
+			0,
+			0,
+			0,
+			0,
+			previewOptions{format: mp4Format, duration: defaultPreviewDuration, fps: defaultPreviewFps, scale: SmallSize, segments: 1},
+			false,
+		},
+		"extension": {
+			".WebM",
+			10,
+			24,
+			720,
+			maxPreviewSegments,
+			previewOptions{format: webmFormat, duration: 10, fps: 24, scale: 720, segments: maxPreviewSegments},
+			false,
+		},
+		"negative duration": {
+			"mp4",
+			-2,
+			0,
+			0,
+			3,
+			previewOptions{format: mp4Format, duration: defaultPreviewDuration, fps: defaultPreviewFps, scale: SmallSize, segments: 3},
+			false,
+		},
+		"unknown format": {
+			"gif",
+			0,
+			0,
+			0,
+			0,
+			previewOptions{},
+			true,
+		},
+		"too many segments": {
+			"",
+			0,
+			0,
+			0,
+			maxPreviewSegments + 1,
+			previewOptions{},
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := newPreviewOptions(testCase.format, testCase.duration, testCase.fps, testCase.scale, testCase.segments)
+
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("newPreviewOptions() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			if got != testCase.want {
+				t.Errorf("newPreviewOptions() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestParsePreviewOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query   string
+		want    previewOptions
+		wantErr bool
+	}{
+		"empty": {
+			"",
+			previewOptions{format: mp4Format, duration: defaultPreviewDuration, fps: defaultPreviewFps, scale: SmallSize, segments: 1},
+			false,
+		},
+		"all": {
+			"format=webm&duration=8.5&fps=10&scale=640&segments=4",
+			previewOptions{format: webmFormat, duration: 8.5, fps: 10, scale: 640, segments: 4},
+			false,
+		},
+		"invalid duration": {
+			"duration=long",
+			previewOptions{},
+			true,
+		},
+		"negative fps": {
+			"fps=-1",
+			previewOptions{},
+			true,
+		},
+		"invalid segments": {
+			"segments=many",
+			previewOptions{},
+			true,
+		},
+		"too many segments": {
+			"segments=11",
+			previewOptions{},
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parsePreviewOptions(httptest.NewRequest(http.MethodPost, "/?"+testCase.query, nil))
+
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("parsePreviewOptions() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+
+			if got != testCase.want {
+				t.Errorf("parsePreviewOptions() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPreviewStartPoints(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		duration        float64
+		segmentDuration float64
+		segments        uint64
+		want            []float64
+	}{
+		"centered": {
+			100,
+			5,
+			1,
+			[]float64{47.5},
+		},
+		"spread": {
+			100,
+			1,
+			4,
+			[]float64{19.5, 39.5, 59.5, 79.5},
+		},
+		"short input": {
+			2,
+			5,
+			1,
+			[]float64{0},
+		},
+		"more segments than seconds": {
+			2,
+			1,
+			3,
+			[]float64{0, 0.5, 1},
+		},
+		"unknown duration": {
+			0,
+			1,
+			3,
+			[]float64{0, 0, 0},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			got := previewStartPoints(testCase.duration, testCase.segmentDuration, testCase.segments)
+
+			if len(got) != len(testCase.want) {
+				t.Fatalf("previewStartPoints() = %v, want %v", got, testCase.want)
+			}
+
+			for index := range got {
+				if math.Abs(got[index]-testCase.want[index]) > 1e-9 {
+					t.Errorf("previewStartPoints() = %v, want %v", got, testCase.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewFilterGraph(t *testing.T) {
+	t.Parallel()
+
+	const scale = "crop='min(iw,ih)':'min(iw,ih)',scale=150:150"
+
+	cases := map[string]struct {
+		info    mediaInfo
+		options previewOptions
+		want    string
+	}{
+		"single segment": {
+			mediaInfo{},
+			previewOptions{fps: 15, scale: SmallSize, segments: 1},
+			"[0:v:0]" + scale + ",fps=15,setsar=1[v0];[v0]concat=n=1:v=1:a=0[preview]",
+		},
+		"segments": {
+			mediaInfo{},
+			previewOptions{fps: 10, scale: SmallSize, segments: 3},
+			"[0:v:0]" + scale + ",fps=10,setsar=1[v0];[1:v:0]" + scale + ",fps=10,setsar=1[v1];[2:v:0]" + scale + ",fps=10,setsar=1[v2];[v0][v1][v2]concat=n=3:v=1:a=0[preview]",
+		},
+		"rotated hdr": {
+			mediaInfo{Orientation: 6, ColorTransfer: "smpte2084"},
+			previewOptions{fps: 15, scale: SmallSize, segments: 1},
+			"[0:v:0]" + toneMappingFilter + ",transpose=clock," + scale + ",fps=15,setsar=1[v0];[v0]concat=n=1:v=1:a=0[preview]",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := previewFilterGraph(testCase.info, testCase.options); got != testCase.want {
+				t.Errorf("previewFilterGraph() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHandlePreviewBadRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query string
+	}{
+		"format": {
+			"format=gif",
+		},
+		"segments": {
+			"segments=11",
+		},
+		"duration": {
+			"duration=long",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/_/preview?"+testCase.query, strings.NewReader("video"))
+
+			Service{}.HandlePreview(writer, request)
+
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf("HandlePreview() = %d, want %d", writer.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+const (
+	transferPQ  = "smpte2084"
+	transferHLG = "arib-std-b67"
+
+	toneMappingFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+)
+
+// orientationFilters maps an EXIF orientation to the filters that display it upright
+var orientationFilters = map[int]string{
+	2: "hflip",
+	3: "hflip,vflip",
+	4: "vflip",
+	5: "transpose=cclock_flip",
+	6: "transpose=clock",
+	7: "transpose=clock_flip",
+	8: "transpose=cclock",
+}
+
+type mediaInfo struct {
+	ColorTransfer  string
+	ColorPrimaries string
+	ColorSpace     string
+	Width          int
+	Height         int
+	Orientation    int
+}
+
+type probeSideData struct {
+	Rotation float64 `json:"rotation"`
+}
+
+type probeOutput struct {
+	Streams []struct {
+		Tags           map[string]string `json:"tags"`
+		ColorTransfer  string            `json:"color_transfer"`
+		ColorPrimaries string            `json:"color_primaries"`
+		ColorSpace     string            `json:"color_space"`
+		SideData       []probeSideData   `json:"side_data_list"`
+		Width          int               `json:"width"`
+		Height         int               `json:"height"`
+	} `json:"streams"`
+	Frames []struct {
+		Tags     map[string]string `json:"tags"`
+		SideData []probeSideData   `json:"side_data_list"`
+	} `json:"frames"`
+}
+
+func (mi mediaInfo) isHDR() bool {
+	return mi.ColorTransfer == transferPQ || mi.ColorTransfer == transferHLG
+}
+
+// videoRange returns the HLS VIDEO-RANGE attribute of the media
+func (mi mediaInfo) videoRange() string {
+	switch mi.ColorTransfer {
+	case transferPQ:
+		return "PQ"
+	case transferHLG:
+		return "HLG"
+	default:
+		return "SDR"
+	}
+}
+
+func (mi mediaInfo) toneMapping() string {
+	if mi.isHDR() {
+		return toneMappingFilter
+	}
+
+	return ""
+}
+
+func (mi mediaInfo) orientation() string {
+	return orientationFilters[mi.Orientation]
+}
+
+func (s Service) getMediaInfo(ctx context.Context, inputName string) (info mediaInfo, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffprobe_info")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-read_intervals", "%+#1", "-show_entries", "stream=width,height,color_transfer,color_primaries,color_space:stream_tags=rotate:stream_side_data=rotation:frame_tags=Orientation:frame_side_data=rotation", "-of", "json", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return info, fmt.Errorf("ffprobe info: %w", err)
+	}
+
+	var output probeOutput
+	if err = json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		return info, fmt.Errorf("parse ffprobe info: %w", err)
+	}
+
+	return output.mediaInfo(), nil
+}
+
+func (po probeOutput) mediaInfo() (info mediaInfo) {
+	if len(po.Streams) == 0 {
+		return info
+	}
+
+	stream := po.Streams[0]
+
+	info.ColorTransfer = stream.ColorTransfer
+	info.ColorPrimaries = stream.ColorPrimaries
+	info.ColorSpace = stream.ColorSpace
+	info.Width = stream.Width
+	info.Height = stream.Height
+
+	for _, frame := range po.Frames {
+		if orientation, err := strconv.Atoi(frame.Tags["Orientation"]); err == nil && orientation > 1 && orientation <= 8 {
+			info.Orientation = orientation
+			return info
+		}
+
+		for _, sideData := range frame.SideData {
+			if orientation := rotationToOrientation(-sideData.Rotation); orientation != 0 {
+				info.Orientation = orientation
+				return info
+			}
+		}
+	}
+
+	for _, sideData := range stream.SideData {
+		if orientation := rotationToOrientation(-sideData.Rotation); orientation != 0 {
+			info.Orientation = orientation
+			return info
+		}
+	}
+
+	if rotate, err := strconv.ParseFloat(strings.TrimSpace(stream.Tags["rotate"]), 64); err == nil {
+		info.Orientation = rotationToOrientation(rotate)
+	}
+
+	return info
+}
+
+// rotationToOrientation converts a clockwise rotation in degrees to its EXIF orientation
+func rotationToOrientation(rotation float64) int {
+	switch int(math.Round(rotation)) % 360 {
+	case 90, -270:
+		return 6
+	case 180, -180:
+		return 3
+	case 270, -90:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func joinFilters(filters ...string) string {
+	var output []byte
+
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+
+		if len(output) > 0 {
+			output = append(output, ',')
+		}
+
+		output = append(output, filter...)
+	}
+
+	return string(output)
+}
@@ -0,0 +1,245 @@
+package vith
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMediaInfoOrientation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		probe       string
+		orientation int
+		filters     string
+	}{
+		"none": {
+			`{"streams":[{"width":4000,"height":3000}],"frames":[{}]}`,
+			0,
+			"crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 1": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"1"}}]}`,
+			0,
+			"crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 2": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"2"}}]}`,
+			2,
+			"hflip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 3": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"3"}}]}`,
+			3,
+			"hflip,vflip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 4": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"4"}}]}`,
+			4,
+			"vflip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 5": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"5"}}]}`,
+			5,
+			"transpose=cclock_flip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 6": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"6"}}]}`,
+			6,
+			"transpose=clock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 7": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"7"}}]}`,
+			7,
+			"transpose=clock_flip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif 8": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"8"}}]}`,
+			8,
+			"transpose=cclock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"exif invalid": {
+			`{"streams":[{}],"frames":[{"tags":{"Orientation":"9"}}]}`,
+			0,
+			"crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"frame side data": {
+			`{"streams":[{}],"frames":[{"side_data_list":[{"rotation":-90}]}]}`,
+			6,
+			"transpose=clock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"stream side data": {
+			`{"streams":[{"side_data_list":[{"rotation":90}]}]}`,
+			8,
+			"transpose=cclock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"rotate tag": {
+			`{"streams":[{"tags":{"rotate":"180"}}]}`,
+			3,
+			"hflip,vflip,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"rotate tag 270": {
+			`{"streams":[{"tags":{"rotate":"270"}}]}`,
+			8,
+			"transpose=cclock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+		"stream side data 270": {
+			`{"streams":[{"side_data_list":[{"rotation":270}]}]}`,
+			6,
+			"transpose=clock,crop='min(iw,ih)':'min(iw,ih)',scale=150:150",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			var output probeOutput
+			if err := json.Unmarshal([]byte(testCase.probe), &output); err != nil {
+				t.Fatal(err)
+			}
+
+			info := output.mediaInfo()
+
+			if info.Orientation != testCase.orientation {
+				t.Errorf("mediaInfo().Orientation = %d, want %d", info.Orientation, testCase.orientation)
+			}
+
+			if got := thumbnailFilters(info, SmallSize); got != testCase.filters {
+				t.Errorf("thumbnailFilters() = `%s`, want `%s`", got, testCase.filters)
+			}
+		})
+	}
+}
+
+func TestOrientationFilters(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		orientation int
+		want        string
+	}{
+		"1 upright": {
+			1,
+			"",
+		},
+		"2 mirrored": {
+			2,
+			"hflip",
+		},
+		"3 rotated 180": {
+			3,
+			"hflip,vflip",
+		},
+		"4 flipped": {
+			4,
+			"vflip",
+		},
+		"5 transposed": {
+			5,
+			"transpose=cclock_flip",
+		},
+		"6 rotated 90 clockwise": {
+			6,
+			"transpose=clock",
+		},
+		"7 transversed": {
+			7,
+			"transpose=clock_flip",
+		},
+		"8 rotated 90 counter clockwise": {
+			8,
+			"transpose=cclock",
+		},
+		"0 unknown": {
+			0,
+			"",
+		},
+		"9 invalid": {
+			9,
+			"",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := (mediaInfo{Orientation: testCase.orientation}).orientation(); got != testCase.want {
+				t.Errorf("orientation() = `%s`, want `%s`", got, testCase.want)
+			}
+
+			if got := orientationFilters[testCase.orientation]; got != testCase.want {
+				t.Errorf("orientationFilters[%d] = `%s`, want `%s`", testCase.orientation, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestRotationToOrientation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		rotation float64
+		want     int
+	}{
+		"none": {
+			0,
+			0,
+		},
+		"90": {
+			90,
+			6,
+		},
+		"180": {
+			180,
+			3,
+		},
+		"270": {
+			270,
+			8,
+		},
+		"-90": {
+			-90,
+			8,
+		},
+		"-180": {
+			-180,
+			3,
+		},
+		"-270": {
+			-270,
+			6,
+		},
+		"full turn": {
+			360,
+			0,
+		},
+		"more than a turn": {
+			450,
+			6,
+		},
+		"less than minus a turn": {
+			-450,
+			8,
+		},
+		"rounded": {
+			89.6,
+			6,
+		},
+		"not a right angle": {
+			45,
+			0,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := rotationToOrientation(testCase.rotation); got != testCase.want {
+				t.Errorf("rotationToOrientation(%g) = %d, want %d", testCase.rotation, got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package vith
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSS returns the maximum resident set size of the process in bytes
+func maxRSS(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return usage.Maxrss * 1024
+	}
+
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vith
+
+import "os"
+
+// maxRSS is only available on Linux
+func maxRSS(_ *os.ProcessState) int64 {
+	return 0
+}
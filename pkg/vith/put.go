@@ -2,8 +2,11 @@ package vith
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"path/filepath"
+	"strconv"
 
 	"github.com/ViBiOh/httputils/v4/pkg/httperror"
 	"github.com/ViBiOh/vith/pkg/model"
@@ -23,8 +26,8 @@ func (s Service) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if itemType != model.TypeVideo {
-		httperror.BadRequest(ctx, w, errors.New("stream are possible for video type only"))
+	if !itemType.IsStreamable() {
+		httperror.BadRequest(ctx, w, errors.New("stream are possible for video or audio type only"))
 		return
 	}
 
@@ -34,12 +37,61 @@ func (s Service) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slog.LogAttrs(ctx, slog.LevelInfo, "Adding stream generation in the work queue", slog.String("input", r.URL.Path))
+	req := model.NewRequest(r.URL.Path, output, itemType, defaultScale)
 
-	select {
-	case s.streamRequestQueue <- model.NewRequest(r.URL.Path, output, itemType, defaultScale):
+	if req.Overlay, err = parseOverlay(r); err != nil {
+		httperror.BadRequest(ctx, w, err)
+		return
+	}
+
+	if rawMode := r.URL.Query().Get("mode"); len(rawMode) != 0 {
+		if req.Mode, err = model.ParseRequestMode(rawMode); err != nil {
+			httperror.BadRequest(ctx, w, err)
+			return
+		}
+	}
+
+	if (req.Mode == model.ModeTranscode) != (filepath.Ext(output) == mp4Extension) {
+		httperror.BadRequest(ctx, w, fmt.Errorf("output must be a `%s` file for transcode mode only", mp4Extension))
+		return
+	}
+
+	if req.Mode == model.ModeTranscode {
+		if itemType != model.TypeVideo {
+			httperror.BadRequest(ctx, w, errors.New("transcode are possible for video type only"))
+			return
+		}
+
+		if req.Height, err = parseUintParam(r, "height"); err != nil {
+			httperror.BadRequest(ctx, w, err)
+			return
+		}
+
+		if req.Bitrate, err = parseUintParam(r, "bitrate"); err != nil {
+			httperror.BadRequest(ctx, w, err)
+			return
+		}
+	}
+
+	slog.LogAttrs(ctx, slog.LevelInfo, "Adding stream generation in the work queue", slog.String("mode", req.Mode.String()), slog.String("input", r.URL.Path), slog.String("output", output))
+
+	if s.enqueue(req) {
 		w.WriteHeader(http.StatusAccepted)
-	case <-s.stop:
+	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 }
+
+func parseUintParam(r *http.Request, name string) (uint64, error) {
+	raw := r.URL.Query().Get(name)
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+
+	return value, nil
+}
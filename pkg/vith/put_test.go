@@ -0,0 +1,119 @@
+package vith
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ViBiOh/absto/pkg/filesystem"
+	"github.com/ViBiOh/vith/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func queueService(t *testing.T) Service {
+	t.Helper()
+
+	storage, err := filesystem.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Service{storage: storage, streamRequestQueue: make(chan queuedRequest, 1), stop: make(chan struct{})}
+}
+
+func TestHandlePutMode(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		query    string
+		wantCode int
+		wantMode model.RequestMode
+	}{
+		"stream": {
+			"?type=video&output=/stream/video.m3u8",
+			http.StatusAccepted,
+			model.ModeStream,
+		},
+		"explicit stream": {
+			"?type=video&mode=stream&output=/stream/video.m3u8",
+			http.StatusAccepted,
+			model.ModeStream,
+		},
+		"transcode": {
+			"?type=video&mode=transcode&output=/transcode/video.mp4&height=720",
+			http.StatusAccepted,
+			model.ModeTranscode,
+		},
+		"mp4 stream": {
+			"?type=video&output=/stream/video.mp4",
+			http.StatusBadRequest,
+			model.ModeStream,
+		},
+		"transcode without mp4": {
+			"?type=video&mode=transcode&output=/transcode/video.mkv",
+			http.StatusBadRequest,
+			model.ModeStream,
+		},
+		"audio transcode": {
+			"?type=audio&mode=transcode&output=/transcode/audio.mp4",
+			http.StatusBadRequest,
+			model.ModeStream,
+		},
+		"unknown mode": {
+			"?type=video&mode=clip&output=/stream/video.m3u8",
+			http.StatusBadRequest,
+			model.ModeStream,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			service := queueService(t)
+
+			writer := httptest.NewRecorder()
+			service.HandlePut(writer, httptest.NewRequest(http.MethodPut, "/videos/video.mov"+testCase.query, nil))
+
+			if writer.Code != testCase.wantCode {
+				t.Fatalf("HandlePut() = %d, want %d", writer.Code, testCase.wantCode)
+			}
+
+			if testCase.wantCode != http.StatusAccepted {
+				if len(service.streamRequestQueue) != 0 {
+					t.Error("HandlePut() queued an invalid request")
+				}
+
+				return
+			}
+
+			if item := <-service.streamRequestQueue; item.req.Mode != testCase.wantMode {
+				t.Errorf("HandlePut() queued mode %s, want %s", item.req.Mode, testCase.wantMode)
+			}
+		})
+	}
+}
+
+func TestAmqpTranscodeHandler(t *testing.T) {
+	t.Parallel()
+
+	service := queueService(t)
+	message := amqp.Delivery{Body: []byte(`{"input":"/videos/video.mov","output":"/transcode/video.mp4","type":"video"}`)}
+
+	if err := service.AmqpTranscodeHandler(context.Background(), message); err != nil {
+		t.Fatalf("AmqpTranscodeHandler() error = %s", err)
+	}
+
+	if item := <-service.streamRequestQueue; item.req.Mode != model.ModeTranscode || item.req.Output != "/transcode/video.mp4" {
+		t.Errorf("AmqpTranscodeHandler() queued %+v", item.req)
+	}
+
+	// with a full queue, a stopping service refuses the message instead of blocking
+	service.streamRequestQueue <- queuedRequest{}
+	close(service.stop)
+
+	if err := service.AmqpTranscodeHandler(context.Background(), message); err == nil {
+		t.Error("AmqpTranscodeHandler() accepted a message while stopping")
+	}
+}
@@ -0,0 +1,311 @@
+package vith
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+const (
+	reconcileMissingThumbnail = "missing_thumbnail"
+	reconcileMissingStream    = "missing_stream"
+	reconcileBrokenStream     = "broken_stream"
+	reconcileOrphan           = "orphan"
+)
+
+var (
+	streamFileRegex  = regexp.MustCompile("^" + streamFilesSuffix)
+	manifestURIRegex = regexp.MustCompile(`URI="([^"]+)"`)
+)
+
+type reconcileEntry struct {
+	Status string         `json:"status"`
+	Output string         `json:"output"`
+	Path   string         `json:"path,omitempty"`
+	Type   model.ItemType `json:"type"`
+}
+
+type reconcileState struct {
+	thumbnails map[string]batchItem
+	streams    map[string]batchItem
+	outputs    map[string]bool
+}
+
+// reconcile compares the sources of the given paths with their outputs, reporting gaps and orphans as JSON lines
+func (s Service) reconcile(ctx context.Context, config *BatchConfig, paths []string, writer io.Writer, summary *BatchSummary) error {
+	items, skipped, err := s.batchItems(ctx, config, BatchReconcile, paths)
+	if err != nil {
+		return err
+	}
+
+	summary.Skipped = skipped
+
+	state := reconcileState{
+		thumbnails: make(map[string]batchItem),
+		streams:    make(map[string]batchItem),
+		outputs:    make(map[string]bool),
+	}
+
+	for _, item := range items {
+		state.thumbnails[batchOutput(config, item.pathname, webpExtension)] = item
+
+		if item.itemType.IsStreamable() {
+			state.streams[batchOutput(config, item.pathname, hlsExtension)] = item
+		}
+	}
+
+	for _, root := range paths {
+		err = s.storage.Walk(ctx, path.Join(config.Output, root), func(item absto.Item) error {
+			if !item.IsDir() {
+				state.outputs[item.Pathname] = true
+			}
+
+			return nil
+		})
+		if err != nil && !absto.IsNotExist(err) {
+			return fmt.Errorf("walk outputs of `%s`: %w", root, err)
+		}
+	}
+
+	var entries []reconcileEntry
+
+	for _, item := range items {
+		if output := batchOutput(config, item.pathname, webpExtension); !state.outputs[output] {
+			entries = append(entries, reconcileEntry{Status: reconcileMissingThumbnail, Path: item.pathname, Output: output, Type: item.itemType})
+		}
+
+		if !item.itemType.IsStreamable() {
+			continue
+		}
+
+		output := batchOutput(config, item.pathname, hlsExtension)
+
+		if !state.outputs[output] {
+			if config.Streams {
+				entries = append(entries, reconcileEntry{Status: reconcileMissingStream, Path: item.pathname, Output: output, Type: item.itemType})
+			}
+
+			continue
+		}
+
+		if missing, err := s.missingSegments(ctx, state, output); err != nil {
+			summary.Failures[output] = err
+		} else if len(missing) > 0 {
+			slog.LogAttrs(ctx, slog.LevelDebug, "stream is broken", slog.String("output", output), slog.Any("missing", missing))
+			entries = append(entries, reconcileEntry{Status: reconcileBrokenStream, Path: item.pathname, Output: output, Type: item.itemType})
+		}
+	}
+
+	listings := make(map[string][]absto.Item)
+
+	for output := range state.outputs {
+		if state.owned(output) {
+			continue
+		}
+
+		if exists, err := s.sourceExists(ctx, config, output, listings); err != nil {
+			summary.Failures[output] = err
+		} else if !exists {
+			entries = append(entries, reconcileEntry{Status: reconcileOrphan, Output: output})
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Status == reconcileOrphan {
+			summary.Orphans++
+		} else {
+			summary.Gaps++
+		}
+
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("marshal reconcile: %w", err)
+		}
+
+		if _, err = fmt.Fprintln(writer, string(payload)); err != nil {
+			return fmt.Errorf("write reconcile: %w", err)
+		}
+	}
+
+	var mutex sync.Mutex
+
+	batchRun(ctx, config, entries, func(entry reconcileEntry) string { return entry.Output }, func(entry reconcileEntry) (bool, error) {
+		return s.reconcileFix(ctx, config, entry, &mutex)
+	}, summary)
+
+	return nil
+}
+
+// owned checks if an output file belongs to the thumbnail or the stream of a source, files unknown to vith are never orphans
+func (rs reconcileState) owned(output string) bool {
+	if path.Ext(output) == webpExtension {
+		_, ok := rs.thumbnails[output]
+		return ok
+	}
+
+	isStreamFile := false
+
+	for index := len(output) - 1; index > 0; index-- {
+		if !streamFileRegex.MatchString(output[index:]) {
+			continue
+		}
+
+		isStreamFile = true
+
+		if _, ok := rs.streams[output[:index]+hlsExtension]; ok {
+			return true
+		}
+	}
+
+	return !isStreamFile
+}
+
+// sourceExists checks in the storage if an output not owned by a walked source still has one, because the walk skips hidden files,
+// unknown extensions and files filtered by globs. Source directories are listed once, the extension of sources being unknown.
+func (s Service) sourceExists(ctx context.Context, config *BatchConfig, output string, listings map[string][]absto.Item) (bool, error) {
+	var owners []string
+
+	if path.Ext(output) == webpExtension {
+		owners = append(owners, output)
+	} else {
+		for index := len(output) - 1; index > 0; index-- {
+			if streamFileRegex.MatchString(output[index:]) {
+				owners = append(owners, output[:index]+hlsExtension)
+			}
+		}
+	}
+
+	for _, owner := range owners {
+		directory := path.Dir(path.Join("/", strings.TrimPrefix(owner, config.Output)))
+
+		items, ok := listings[directory]
+		if !ok {
+			var err error
+
+			items, err = s.storage.List(ctx, directory)
+			if err != nil && !absto.IsNotExist(s.storage.ConvertError(err)) {
+				return false, fmt.Errorf("list sources of `%s`: %w", directory, err)
+			}
+
+			listings[directory] = items
+		}
+
+		for _, item := range items {
+			if !item.IsDir() && batchOutput(config, item.Pathname, path.Ext(owner)) == owner {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// missingSegments lists the files referenced by a manifest, and the playlists it references, that are not in the storage
+func (s Service) missingSegments(ctx context.Context, state reconcileState, manifest string) (missing []string, err error) {
+	visited := map[string]bool{manifest: true}
+	playlists := []string{manifest}
+
+	for len(playlists) > 0 {
+		playlist := playlists[0]
+		playlists = playlists[1:]
+
+		content, err := s.readFile(ctx, playlist)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest `%s`: %w", playlist, err)
+		}
+
+		for _, uri := range manifestURIs(content) {
+			if strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") || strings.Contains(uri, "{") {
+				continue
+			}
+
+			name := path.Join(path.Dir(playlist), uri)
+			if visited[name] {
+				continue
+			}
+
+			visited[name] = true
+
+			if !state.outputs[name] {
+				missing = append(missing, name)
+			} else if path.Ext(name) == hlsExtension {
+				playlists = append(playlists, name)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+func manifestURIs(content []byte) (uris []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case len(line) == 0:
+		case strings.HasPrefix(line, "#EXT-X-KEY"):
+			// keys are served from the key folder or an external URI, they are not stored alongside segments
+		case strings.HasPrefix(line, "#"):
+			for _, match := range manifestURIRegex.FindAllStringSubmatch(line, -1) {
+				uris = append(uris, match[1])
+			}
+		default:
+			uris = append(uris, line)
+		}
+	}
+
+	return uris
+}
+
+// reconcileFix enqueues the generation of gaps and deletes orphans, according to the configuration, returning false if nothing has been done
+func (s Service) reconcileFix(ctx context.Context, config *BatchConfig, entry reconcileEntry, mutex sync.Locker) (bool, error) {
+	if entry.Status == reconcileOrphan {
+		if !config.Prune {
+			return false, nil
+		}
+
+		slog.LogAttrs(ctx, slog.LevelInfo, "Deleting orphan...", slog.String("output", entry.Output))
+
+		if err := s.storage.RemoveAll(ctx, entry.Output); err != nil {
+			return false, fmt.Errorf("delete orphan: %w", err)
+		}
+
+		return true, nil
+	}
+
+	if !config.Enqueue {
+		return false, nil
+	}
+
+	routingKey, command := config.ThumbnailRoutingKey, BatchThumbnail
+	if entry.Status != reconcileMissingThumbnail {
+		routingKey, command = config.StreamRoutingKey, BatchStream
+	}
+
+	if s.amqpClient != nil {
+		if err := s.amqpClient.PublishJSON(ctx, model.NewRequest(entry.Path, entry.Output, entry.Type, defaultScale), s.amqpExchange, routingKey); err != nil {
+			return false, fmt.Errorf("publish amqp message: %w", err)
+		}
+
+		return true, nil
+	}
+
+	// without AMQP, generation is done in process, overwriting broken streams
+	overwrite := *config
+	overwrite.Overwrite = true
+
+	return s.batchProcess(ctx, &overwrite, command, batchItem{pathname: entry.Path, itemType: entry.Type}, io.Discard, mutex)
+}
@@ -0,0 +1,121 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ViBiOh/absto/pkg/filesystem"
+)
+
+func TestReconcileOrphans(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	for _, name := range []string{
+		"videos/kept.mp4",
+		"videos/excluded.mp4",
+		"videos/.hidden.jpg",
+		"videos/notes.unknown",
+		".vith/videos/kept.webp",
+		".vith/videos/excluded.webp",
+		".vith/videos/excluded.m3u8",
+		".vith/videos/excluded0.ts",
+		".vith/videos/.hidden.webp",
+		".vith/videos/notes.webp",
+		".vith/videos/gone.webp",
+		".vith/videos/gone.m3u8",
+		".vith/videos/gone0.ts",
+		".vith/videos/removed/gone.webp",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, err := filesystem.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := Service{storage: storage}
+	config := &BatchConfig{Output: "/.vith", Layout: LayoutMirror, Exclude: []string{"excluded.*"}, Parallel: 1}
+
+	var output bytes.Buffer
+
+	summary, err := service.Batch(context.Background(), config, BatchReconcile, []string{"/videos"}, &output)
+	if err != nil {
+		t.Fatalf("Batch() error = %s", err)
+	}
+
+	var orphans []string
+
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var entry reconcileEntry
+		if err = decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Status == reconcileOrphan {
+			orphans = append(orphans, entry.Output)
+		}
+	}
+
+	slices.Sort(orphans)
+
+	want := []string{"/.vith/videos/gone.m3u8", "/.vith/videos/gone.webp", "/.vith/videos/gone0.ts", "/.vith/videos/removed/gone.webp"}
+
+	if !slices.Equal(orphans, want) {
+		t.Errorf("orphans = %v, want %v", orphans, want)
+	}
+
+	if len(summary.Failures) != 0 {
+		t.Errorf("failures = %v", summary.Failures)
+	}
+}
+
+func TestManifestURIs(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content string
+		want    []string
+	}{
+		"empty": {
+			"",
+			nil,
+		},
+		"media": {
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXTINF:4.000000,\nvideo0.ts\n\n#EXTINF:2.500000,\nvideo1.ts\n#EXT-X-ENDLIST\n",
+			[]string{"video0.ts", "video1.ts"},
+		},
+		"key": {
+			"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/video_key0.key\"\n#EXTINF:4.000000,\nvideo0.ts\n",
+			[]string{"video0.ts"},
+		},
+		"master": {
+			"#EXTM3U\n#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",URI=\"video_sub0.m3u8\"\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nvideo.m3u8\n",
+			[]string{"video_sub0.m3u8", "video.m3u8"},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := manifestURIs([]byte(testCase.content)); !slices.Equal(got, testCase.want) {
+				t.Errorf("manifestURIs() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/ViBiOh/httputils/v4/pkg/httperror"
+	"github.com/ViBiOh/vith/pkg/model"
+)
+
+var (
+	errRemoteForbidden = errors.New("remote url is forbidden")
+	errRemoteFetch     = errors.New("fetch remote url")
+
+	// nonPublicPrefixes completes the netip predicates with the special-purpose ranges of IANA registries
+	nonPublicPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+	}
+
+	// nat64Prefix and sixToFourPrefix carry an IPv4 address, that is the one reached through the gateway
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+func newRemoteClient(timeout time.Duration, maxRedirects uint, schemes []string, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+
+			return checkRemoteAddress(address)
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// a proxy would connect on our behalf, bypassing the address check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if uint(len(via)) > maxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", errRemoteForbidden, maxRedirects)
+			}
+
+			if !slices.Contains(schemes, req.URL.Scheme) {
+				return fmt.Errorf("%w: redirect to scheme `%s`", errRemoteForbidden, req.URL.Scheme)
+			}
+
+			return nil
+		},
+	}
+}
+
+// checkRemoteAddress rejects the addresses that aren't publicly routable, it's called after DNS resolution to prevent rebinding
+func checkRemoteAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errRemoteForbidden, err)
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errRemoteForbidden, err)
+	}
+
+	ip = embeddedIPv4(ip.Unmap())
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: address %s isn't public", errRemoteForbidden, ip)
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: address %s isn't public", errRemoteForbidden, ip)
+		}
+	}
+
+	return nil
+}
+
+// embeddedIPv4 returns the IPv4 address embedded in a NAT64 or 6to4 address, the address itself otherwise
+func embeddedIPv4(ip netip.Addr) netip.Addr {
+	raw := ip.As16()
+
+	switch {
+	case nat64Prefix.Contains(ip):
+		return netip.AddrFrom4([4]byte(raw[12:16]))
+	case sixToFourPrefix.Contains(ip):
+		return netip.AddrFrom4([4]byte(raw[2:6]))
+	default:
+		return ip
+	}
+}
+
+func (s Service) HandleRemote(w http.ResponseWriter, r *http.Request) {
+	if s.remoteClient == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+
+	itemType, err := model.ParseItemType(r.URL.Query().Get("type"))
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "remote", "", "invalid")
+		return
+	}
+
+	options, err := s.parseThumbnailOptions(r)
+	if err != nil {
+		httperror.BadRequest(ctx, w, err)
+		s.increaseMetric(ctx, "http", "remote", itemType.String(), "invalid")
+		return
+	}
+
+	ctx, done := s.startJob(ctx, "thumbnail", itemType)
+	defer done()
+
+	body, size, err := s.fetchRemote(ctx, r.URL.Query().Get("url"))
+	if err != nil {
+		handleRemoteError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "remote", itemType.String(), "fetch_error")
+		return
+	}
+	defer closeWithLog(ctx, body, "HandleRemote", "body")
+
+	// a chunked response has no length, the largest accepted body is reserved
+	if size < 0 {
+		size = s.remoteMaxSize
+	}
+
+	release, err := s.reserveTmp(ctx, uint64(size))
+	if err != nil {
+		handleJobError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "remote", itemType.String(), "rejected")
+		return
+	}
+	defer release()
+
+	state, err := s.bodyThumbnail(ctx, w, r, body, itemType, options)
+	if err != nil {
+		handleRemoteError(ctx, w, err)
+		s.increaseMetric(ctx, "http", "remote", itemType.String(), "error")
+		return
+	}
+
+	s.increaseMetric(ctx, "http", "remote", itemType.String(), state)
+}
+
+// fetchRemote requests the url, its body is limited to the max size
+func (s Service) fetchRemote(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid url `%s`", errRemoteForbidden, rawURL)
+	}
+
+	if !slices.Contains(s.remoteSchemes, target.Scheme) {
+		return nil, 0, fmt.Errorf("%w: scheme `%s` isn't allowed", errRemoteForbidden, target.Scheme)
+	}
+
+	if len(target.Host) == 0 {
+		return nil, 0, fmt.Errorf("%w: url `%s` has no host", errRemoteForbidden, rawURL)
+	}
+
+	if target.User != nil {
+		return nil, 0, fmt.Errorf("%w: credentials aren't allowed", errRemoteForbidden)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errRemoteForbidden, err)
+	}
+
+	req.Header.Set("User-Agent", "vith")
+
+	resp, err := s.remoteClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errRemoteFetch, err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		closeWithLog(ctx, resp.Body, "fetchRemote", rawURL)
+		return nil, 0, fmt.Errorf("%w: HTTP/%d", errRemoteFetch, resp.StatusCode)
+	}
+
+	if resp.ContentLength > s.remoteMaxSize {
+		closeWithLog(ctx, resp.Body, "fetchRemote", rawURL)
+		return nil, 0, fmt.Errorf("%w: %d bytes is above the limit of %d bytes", errRemoteForbidden, resp.ContentLength, s.remoteMaxSize)
+	}
+
+	return http.MaxBytesReader(nil, resp.Body, s.remoteMaxSize), resp.ContentLength, nil
+}
+
+// handleRemoteError answers 400 for forbidden urls, 502 when the remote server fails
+func handleRemoteError(ctx context.Context, w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, errRemoteForbidden):
+		httperror.BadRequest(ctx, w, err)
+
+	case errors.As(err, &maxBytesErr):
+		httperror.BadRequest(ctx, w, fmt.Errorf("%w: body is above the limit of %d bytes", errRemoteForbidden, maxBytesErr.Limit))
+
+	case errors.Is(err, errRemoteFetch):
+		httperror.Log(ctx, err, http.StatusBadGateway, "remote")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+
+	default:
+		handleJobError(ctx, w, err)
+	}
+}
@@ -0,0 +1,198 @@
+package vith
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheckRemoteAddress(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		address string
+		want    error
+	}{
+		"loopback": {
+			"127.0.0.1:80",
+			errRemoteForbidden,
+		},
+		"loopback v6": {
+			"[::1]:443",
+			errRemoteForbidden,
+		},
+		"rfc1918 10/8": {
+			"10.1.2.3:80",
+			errRemoteForbidden,
+		},
+		"rfc1918 172.16/12": {
+			"172.20.0.1:80",
+			errRemoteForbidden,
+		},
+		"rfc1918 192.168/16": {
+			"192.168.1.1:80",
+			errRemoteForbidden,
+		},
+		"shared 100.64/10": {
+			"100.100.100.100:80",
+			errRemoteForbidden,
+		},
+		"link-local": {
+			"169.254.169.254:80",
+			errRemoteForbidden,
+		},
+		"link-local v6": {
+			"[fe80::1]:80",
+			errRemoteForbidden,
+		},
+		"unique local v6": {
+			"[fd00::1]:80",
+			errRemoteForbidden,
+		},
+		"v4-mapped loopback": {
+			"[::ffff:127.0.0.1]:80",
+			errRemoteForbidden,
+		},
+		"v4-mapped private": {
+			"[::ffff:10.0.0.1]:80",
+			errRemoteForbidden,
+		},
+		"unspecified": {
+			"0.0.0.0:80",
+			errRemoteForbidden,
+		},
+		"unspecified v6": {
+			"[::]:80",
+			errRemoteForbidden,
+		},
+		"multicast": {
+			"224.0.0.1:80",
+			errRemoteForbidden,
+		},
+		"no port": {
+			"93.184.215.14",
+			errRemoteForbidden,
+		},
+		"hostname": {
+			"localhost:80",
+			errRemoteForbidden,
+		},
+		"nat64 loopback": {
+			"[64:ff9b::7f00:1]:80",
+			errRemoteForbidden,
+		},
+		"nat64 private": {
+			"[64:ff9b::a00:1]:80",
+			errRemoteForbidden,
+		},
+		"6to4 private": {
+			"[2002:c0a8:101::1]:80",
+			errRemoteForbidden,
+		},
+		"6to4 link-local": {
+			"[2002:a9fe:a9fe::1]:80",
+			errRemoteForbidden,
+		},
+		"nat64 public": {
+			"[64:ff9b::5db8:d70e]:443",
+			nil,
+		},
+		"6to4 public": {
+			"[2002:5db8:d70e::1]:443",
+			nil,
+		},
+		"public": {
+			"93.184.215.14:443",
+			nil,
+		},
+		"public v6": {
+			"[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443",
+			nil,
+		},
+		"shared boundary": {
+			"100.128.0.1:80",
+			nil,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := checkRemoteAddress(testCase.address); !errors.Is(got, testCase.want) {
+				t.Errorf("checkRemoteAddress() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHandleRemoteDisabled(t *testing.T) {
+	t.Parallel()
+
+	writer := httptest.NewRecorder()
+	Service{}.HandleRemote(writer, httptest.NewRequest(http.MethodGet, "/_/remote?type=image&url=https://example.com/image.jpg", nil))
+
+	if writer.Code != http.StatusNotFound {
+		t.Errorf("HandleRemote() = %d, want %d", writer.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleRemoteReservation(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("not really an image")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("chunked") {
+			// flushing before the end sends the body without a Content-Length
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	cases := map[string]struct {
+		query string
+		want  int
+	}{
+		"chunked reserves the max size": {
+			"chunked",
+			http.StatusServiceUnavailable,
+		},
+		"known length": {
+			"length",
+			http.StatusNotImplemented,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			tmpFolder := t.TempDir()
+
+			service := Service{
+				remoteClient:  newRemoteClient(time.Second, 0, []string{"http"}, true),
+				remoteSchemes: []string{"http"},
+				remoteMaxSize: 1 << 20,
+				tmpFolder:     tmpFolder,
+				tmpBudget:     newTmpBudget(tmpFolder, tmpFolder, 1<<10, 0, time.Millisecond),
+			}
+
+			writer := httptest.NewRecorder()
+			service.HandleRemote(writer, httptest.NewRequest(http.MethodGet, "/_/remote?type=image&url="+server.URL+"/?"+testCase.query, nil))
+
+			if writer.Code != testCase.want {
+				body, _ := io.ReadAll(writer.Body)
+				t.Errorf("HandleRemote() = %d `%s`, want %d", writer.Code, body, testCase.want)
+			}
+		})
+	}
+}
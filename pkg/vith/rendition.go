@@ -0,0 +1,120 @@
+package vith
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	audioPolicyFirst    = "first"
+	audioPolicyLanguage = "language"
+	audioPolicyAll      = "all"
+
+	audioGroup = "audio"
+)
+
+// validateAudioPolicy rejects an unknown policy, that would silently keep the default track only
+func validateAudioPolicy(policy, language string) error {
+	switch policy {
+	case audioPolicyFirst, audioPolicyAll:
+		return nil
+	case audioPolicyLanguage:
+		if len(language) == 0 {
+			return fmt.Errorf("audio policy `%s` requires a language", audioPolicyLanguage)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown audio policy `%s`, expected %s, %s or %s", policy, audioPolicyFirst, audioPolicyLanguage, audioPolicyAll)
+	}
+}
+
+// selectAudioTracks returns the track muxed with the video, nil for ffmpeg's default one, and the tracks published as separate renditions
+func (s Service) selectAudioTracks(tracks []mediaTrack) (*mediaTrack, []mediaTrack) {
+	if len(tracks) == 0 {
+		return nil, nil
+	}
+
+	preferred := -1
+	if len(s.audioLanguage) > 0 {
+		for index, track := range tracks {
+			if strings.EqualFold(track.Language, s.audioLanguage) {
+				preferred = index
+				break
+			}
+		}
+	}
+
+	switch s.audioPolicy {
+	case audioPolicyLanguage:
+		if preferred == -1 {
+			return nil, nil
+		}
+
+		return &tracks[preferred], nil
+
+	case audioPolicyAll:
+		if preferred == -1 {
+			preferred = 0
+		}
+
+		others := make([]mediaTrack, 0, len(tracks)-1)
+		others = append(others, tracks[:preferred]...)
+		others = append(others, tracks[preferred+1:]...)
+
+		return &tracks[preferred], others
+
+	default:
+		return nil, nil
+	}
+}
+
+func audioMappingOptions(track *mediaTrack) []string {
+	if track == nil {
+		return nil
+	}
+
+	return []string{"-map", "0:v:0", "-map", fmt.Sprintf("0:%d", track.Index)}
+}
+
+// generateAudioRenditions streams each additional audio track in its own audio only playlist
+func (s Service) generateAudioRenditions(ctx context.Context, inputName, outputName string, main *mediaTrack, others []mediaTrack) []hlsRendition {
+	var renditions []hlsRendition
+
+	for _, track := range others {
+		playlistName := audioStreamName(outputName, len(renditions))
+
+		if err := s.runStream(ctx, inputName, playlistName, []string{"-map", fmt.Sprintf("0:%d", track.Index), "-vn"}); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "generate audio rendition", slog.String("input", inputName), slog.Int("index", track.Index), slog.Any("error", err))
+			continue
+		}
+
+		renditions = append(renditions, hlsRendition{
+			kind:     "AUDIO",
+			group:    audioGroup,
+			name:     track.name(),
+			language: track.language(),
+			uri:      filepath.Base(playlistName),
+		})
+	}
+
+	if len(renditions) == 0 {
+		return nil
+	}
+
+	// the main track is muxed in the video segments, so its rendition has no URI
+	return append([]hlsRendition{{
+		kind:      "AUDIO",
+		group:     audioGroup,
+		name:      main.name(),
+		language:  main.language(),
+		isDefault: true,
+	}}, renditions...)
+}
+
+func audioStreamName(name string, index int) string {
+	return fmt.Sprintf("%s%s%d%s", strings.TrimSuffix(name, hlsExtension), audioSuffix, index, hlsExtension)
+}
@@ -0,0 +1,156 @@
+package vith
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateAudioPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		policy   string
+		language string
+		wantErr  bool
+	}{
+		"first": {
+			audioPolicyFirst,
+			"",
+			false,
+		},
+		"all": {
+			audioPolicyAll,
+			"fre",
+			false,
+		},
+		"language": {
+			audioPolicyLanguage,
+			"fre",
+			false,
+		},
+		"language without language": {
+			audioPolicyLanguage,
+			"",
+			true,
+		},
+		"typo": {
+			"al",
+			"",
+			true,
+		},
+		"empty": {
+			"",
+			"",
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if err := validateAudioPolicy(testCase.policy, testCase.language); (err != nil) != testCase.wantErr {
+				t.Errorf("validateAudioPolicy() error = %v, wantErr %t", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectAudioTracks(t *testing.T) {
+	t.Parallel()
+
+	english := mediaTrack{Index: 1, Codec: "aac", Language: "eng"}
+	french := mediaTrack{Index: 2, Codec: "ac3", Language: "FRE"}
+	commentary := mediaTrack{Index: 3, Codec: "aac", Language: "eng", Title: "Commentary"}
+	unknown := mediaTrack{Index: 4, Codec: "aac"}
+
+	tracks := []mediaTrack{english, french, commentary, unknown}
+
+	cases := map[string]struct {
+		policy     string
+		language   string
+		tracks     []mediaTrack
+		wantMain   *mediaTrack
+		wantOthers []mediaTrack
+	}{
+		"no track": {
+			audioPolicyAll,
+			"fre",
+			nil,
+			nil,
+			nil,
+		},
+		"first": {
+			audioPolicyFirst,
+			"fre",
+			tracks,
+			nil,
+			nil,
+		},
+		"language": {
+			audioPolicyLanguage,
+			"fre",
+			tracks,
+			&french,
+			nil,
+		},
+		"language first match": {
+			audioPolicyLanguage,
+			"eng",
+			tracks,
+			&english,
+			nil,
+		},
+		"language unknown": {
+			audioPolicyLanguage,
+			"ger",
+			tracks,
+			nil,
+			nil,
+		},
+		"all": {
+			audioPolicyAll,
+			"",
+			tracks,
+			&english,
+			[]mediaTrack{french, commentary, unknown},
+		},
+		"all with language": {
+			audioPolicyAll,
+			"fre",
+			tracks,
+			&french,
+			[]mediaTrack{english, commentary, unknown},
+		},
+		"all with unknown language": {
+			audioPolicyAll,
+			"ger",
+			tracks,
+			&english,
+			[]mediaTrack{french, commentary, unknown},
+		},
+		"all single track": {
+			audioPolicyAll,
+			"",
+			[]mediaTrack{unknown},
+			&unknown,
+			[]mediaTrack{},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			gotMain, gotOthers := Service{audioPolicy: testCase.policy, audioLanguage: testCase.language}.selectAudioTracks(testCase.tracks)
+
+			if !reflect.DeepEqual(gotMain, testCase.wantMain) {
+				t.Errorf("selectAudioTracks() main = %+v, want %+v", gotMain, testCase.wantMain)
+			}
+
+			if !reflect.DeepEqual(gotOthers, testCase.wantOthers) {
+				t.Errorf("selectAudioTracks() others = %+v, want %+v", gotOthers, testCase.wantOthers)
+			}
+		})
+	}
+}
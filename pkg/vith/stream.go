@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/ViBiOh/absto/pkg/filesystem"
 	"github.com/ViBiOh/absto/pkg/s3"
@@ -18,6 +20,13 @@ import (
 	"github.com/ViBiOh/vith/pkg/model"
 )
 
+const streamAudioBandwidth = 128_000
+
+type queuedRequest struct {
+	enqueuedAt time.Time
+	req        model.Request
+}
+
 func (s Service) Done() <-chan struct{} {
 	return s.done
 }
@@ -42,13 +51,37 @@ func (s Service) Start(ctx context.Context) {
 		}
 	}()
 
-	for req := range s.streamRequestQueue {
+	for item := range s.streamRequestQueue {
+		req := item.req
+
+		if req.Mode == model.ModeTranscode {
+			s.recordQueueWait(withOperation(ctx, "transcode", req.ItemType), item.enqueuedAt)
+
+			if err := s.generateTranscode(context.Background(), req); err != nil {
+				slog.LogAttrs(ctx, slog.LevelError, "generate transcode", slog.Any("error", err))
+			}
+
+			continue
+		}
+
+		s.recordQueueWait(withOperation(ctx, "stream", req.ItemType), item.enqueuedAt)
+
 		if err := s.generateStream(context.Background(), req); err != nil {
 			slog.LogAttrs(ctx, slog.LevelError, "generate stream", slog.Any("error", err))
 		}
 	}
 }
 
+// enqueue adds the request to the work queue, false if the service is stopping
+func (s Service) enqueue(req model.Request) bool {
+	select {
+	case s.streamRequestQueue <- queuedRequest{req: req, enqueuedAt: time.Now()}:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
 func (s Service) stopOnce() {
 	select {
 	case <-s.stop:
@@ -63,6 +96,13 @@ func (s Service) generateStream(ctx context.Context, req model.Request) error {
 	ctx, end := telemetry.StartSpan(ctx, s.tracer, "stream")
 	defer end(&err)
 
+	ctx, done := s.startJob(ctx, "stream", req.ItemType)
+	defer done()
+
+	if err = s.requireFeature("stream"); err != nil {
+		return err
+	}
+
 	log := slog.With("input", req.Input).With("output", req.Output)
 	log.InfoContext(ctx, "Generating stream...")
 
@@ -72,6 +112,14 @@ func (s Service) generateStream(ctx context.Context, req model.Request) error {
 	}
 	defer finalizeInput()
 
+	if s.storage.Name() == s3.Name {
+		release, err := s.reserveTmp(ctx, s.streamTmpEstimate(ctx, inputName, req.ItemType))
+		if err != nil {
+			return fmt.Errorf("reserve stream space: %w", err)
+		}
+		defer release()
+	}
+
 	outputName, finalizeStream, err := s.getOutputStreamName(ctx, req.Output)
 	if err != nil {
 		return fmt.Errorf("get video filename: %w", err)
@@ -80,9 +128,122 @@ func (s Service) generateStream(ctx context.Context, req model.Request) error {
 		if finalizeErr := finalizeStream(); finalizeErr != nil {
 			slog.LogAttrs(ctx, slog.LevelError, "finalize stream", slog.Any("error", finalizeErr))
 		}
+
+		if keysErr := s.moveStreamKeys(ctx, req.Output); keysErr != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "move stream keys", slog.Any("error", keysErr))
+		}
 	}()
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-hwaccel", "auto", "-i", inputName, "-codec:v", "libx264", "-preset", "superfast", "-codec:a", "aac", "-b:a", "128k", "-ac", "2", "-y", "-f", "hls", "-hls_time", "4", "-hls_playlist_type", "event", "-hls_flags", "independent_segments", "-threads", "2", outputName)
+	if req.ItemType == model.TypeAudio {
+		err = s.runStream(ctx, inputName, outputName, streamAudioOptions())
+	} else {
+		err = s.generateVideoStream(ctx, inputName, outputName, req)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.recordInputSize(ctx, inputName)
+
+	if files, listErr := listLocalFiles(strings.TrimSuffix(outputName, hlsExtension), streamFilesSuffix); listErr == nil {
+		s.recordOutputSize(ctx, files...)
+	}
+
+	log.InfoContext(ctx, "Generation succeeded!")
+
+	return nil
+}
+
+func (s Service) generateVideoStream(ctx context.Context, inputName, outputName string, req model.Request) error {
+	info, err := s.getMediaInfo(ctx, inputName)
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video info", slog.String("input", inputName), slog.Any("error", err))
+	}
+
+	overlay, cleanOverlay, err := s.prepareOverlay(ctx, s.overlayOrDefault(req.Overlay))
+	if err != nil {
+		return fmt.Errorf("prepare overlay: %w", err)
+	}
+	defer cleanOverlay()
+
+	audioTracks, err := s.getTracks(ctx, inputName, "a")
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get audio tracks", slog.String("input", inputName), slog.Any("error", err))
+	}
+
+	mainAudio, otherAudios := s.selectAudioTracks(audioTracks)
+	audioMapping := audioMappingOptions(mainAudio)
+
+	if err = s.runStream(ctx, inputName, outputName, slices.Concat(audioMapping, streamSdrOptions(info, overlay))); err != nil {
+		return err
+	}
+
+	variants := []hlsVariant{{uri: path.Base(outputName), videoRange: "SDR"}}
+
+	if s.streamHdr && info.isHDR() {
+		// the sdr rendition is complete, a failed hdr one is left out of the master playlist rather than failing the whole stream
+		if err = s.generateHdrStream(ctx, inputName, req.Output, slices.Concat(audioMapping, streamHdrOptions(info, overlay))); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "generate hdr stream, publishing sdr only", slog.String("input", inputName), slog.Any("error", err))
+		} else {
+			variants = append(variants, hlsVariant{uri: path.Base(hdrStreamName(outputName)), videoRange: info.videoRange()})
+		}
+	}
+
+	bitrate, duration, err := s.getVideoDetails(ctx, inputName)
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video details", slog.String("input", inputName), slog.Any("error", err))
+	}
+
+	renditions := s.generateAudioRenditions(ctx, inputName, outputName, mainAudio, otherAudios)
+
+	subtitles, err := s.extractSubtitles(ctx, inputName, outputName, duration)
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "extract subtitles", slog.String("input", inputName), slog.Any("error", err))
+	}
+
+	renditions = append(renditions, subtitles...)
+
+	if len(variants) == 1 && len(renditions) == 0 {
+		return nil
+	}
+
+	for index := range variants {
+		variants[index].bandwidth = bitrate + streamAudioBandwidth
+	}
+
+	return writeMasterPlaylist(outputName, variants, renditions)
+}
+
+func (s Service) generateHdrStream(ctx context.Context, inputName, output string, videoOpts []string) (err error) {
+	outputName, finalizeStream, err := s.getOutputStreamName(ctx, hdrStreamName(output))
+	if err != nil {
+		return fmt.Errorf("get hdr video filename: %w", err)
+	}
+
+	defer func() {
+		if finalizeErr := finalizeStream(); finalizeErr != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "finalize hdr stream", slog.Any("error", finalizeErr))
+		}
+	}()
+
+	return s.runStream(ctx, inputName, outputName, videoOpts)
+}
+
+func (s Service) runStream(ctx context.Context, inputName, outputName string, videoOpts []string) error {
+	encryption, err := s.prepareEncryption(ctx, outputName)
+	if err != nil {
+		return fmt.Errorf("prepare encryption: %w", err)
+	}
+	defer encryption.close(ctx)
+
+	ffmpegOpts := []string{"-hwaccel", "auto", "-noautorotate", "-i", inputName}
+	ffmpegOpts = append(ffmpegOpts, videoOpts...)
+	ffmpegOpts = append(ffmpegOpts, "-codec:a", "aac", "-b:a", fmt.Sprintf("%dk", streamAudioBandwidth/1000), "-ac", "2", "-y", "-f", "hls", "-hls_time", "4", "-hls_playlist_type", "event", "-hls_flags", encryption.hlsFlags())
+	ffmpegOpts = append(ffmpegOpts, encryption.options()...)
+	ffmpegOpts = append(ffmpegOpts, "-threads", "2", outputName)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegOpts...)
 
 	buffer := bufferPool.Get().(*bytes.Buffer)
 	defer bufferPool.Put(buffer)
@@ -91,8 +252,7 @@ func (s Service) generateStream(ctx context.Context, req model.Request) error {
 	cmd.Stdout = buffer
 	cmd.Stderr = buffer
 
-	err = cmd.Run()
-	if err != nil {
+	if err = s.runCommand(ctx, cmd); err != nil {
 		err = fmt.Errorf("generate stream video: %s\n%s", err, buffer.Bytes())
 
 		if cleanErr := s.cleanLocalStream(ctx, outputName); cleanErr != nil {
@@ -102,11 +262,47 @@ func (s Service) generateStream(ctx context.Context, req model.Request) error {
 		return err
 	}
 
-	log.InfoContext(ctx, "Generation succeeded!")
-
 	return nil
 }
 
+func streamSdrOptions(info mediaInfo, overlay preparedOverlay) []string {
+	options := []string{"-codec:v", "libx264", "-preset", "superfast", "-metadata:s:v:0", "rotate=0"}
+
+	if filters := overlay.apply(joinFilters(info.toneMapping(), info.orientation())); len(filters) > 0 {
+		options = append(options, "-vf", filters)
+	}
+
+	if info.isHDR() {
+		options = append(options, "-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709")
+	}
+
+	return options
+}
+
+func streamHdrOptions(info mediaInfo, overlay preparedOverlay) []string {
+	primaries := info.ColorPrimaries
+	if len(primaries) == 0 {
+		primaries = "bt2020"
+	}
+
+	colorSpace := info.ColorSpace
+	if len(colorSpace) == 0 {
+		colorSpace = "bt2020nc"
+	}
+
+	options := []string{"-codec:v", "libx265", "-preset", "superfast", "-tag:v", "hvc1", "-metadata:s:v:0", "rotate=0"}
+
+	if filters := overlay.apply(info.orientation()); len(filters) > 0 {
+		options = append(options, "-vf", filters)
+	}
+
+	return append(options, "-pix_fmt", "yuv420p10le", "-x265-params", "hdr-opt=1:repeat-headers=1", "-color_primaries", primaries, "-color_trc", info.ColorTransfer, "-colorspace", colorSpace)
+}
+
+func hdrStreamName(name string) string {
+	return strings.TrimSuffix(name, hlsExtension) + hdrSuffix + hlsExtension
+}
+
 func (s Service) isValidStreamName(ctx context.Context, streamName string, shouldExist bool) error {
 	if len(streamName) == 0 {
 		return errors.New("name is required")
@@ -156,17 +352,21 @@ func (s Service) finalizeStreamForS3(ctx context.Context, localName, destName st
 		}
 
 		baseHlsName := strings.TrimSuffix(localName, hlsExtension)
-		segments, err := filepath.Glob(fmt.Sprintf("%s*.ts", baseHlsName))
+		files, err := listLocalFiles(baseHlsName, streamFilesSuffix)
 		if err != nil {
-			return fmt.Errorf("list hls segments for `%s`: %w", baseHlsName, err)
+			return fmt.Errorf("list hls files for `%s`: %w", baseHlsName, err)
 		}
 
 		outputDir := path.Dir(destName)
 
-		for _, file := range segments {
-			segmentName := path.Join(outputDir, filepath.Base(file))
-			if err = s.copyAndCloseLocalFile(ctx, file, segmentName); err != nil {
-				return fmt.Errorf("copy segment to `%s`: %w", segmentName, err)
+		for _, file := range files {
+			if file == localName {
+				continue
+			}
+
+			fileName := path.Join(outputDir, filepath.Base(file))
+			if err = s.copyAndCloseLocalFile(ctx, file, fileName); err != nil {
+				return fmt.Errorf("copy `%s` to `%s`: %w", file, fileName, err)
 			}
 		}
 
@@ -180,8 +380,12 @@ func (s Service) finalizeStreamForS3(ctx context.Context, localName, destName st
 
 func (s Service) cleanLocalStream(ctx context.Context, name string) error {
 	return s.cleanStream(ctx, name, func(_ context.Context, name string) error {
-		return os.Remove(name)
-	}, func(_ context.Context, name string) ([]string, error) {
-		return filepath.Glob(name)
-	}, "*.ts")
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}, func(_ context.Context, rawName, suffix string) ([]string, error) {
+		return listLocalFiles(rawName, suffix)
+	}, streamFilesSuffix)
 }
@@ -0,0 +1,154 @@
+package vith
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const (
+	probePQ  = `{"streams":[{"width":3840,"height":2160,"color_transfer":"smpte2084","color_primaries":"bt2020","color_space":"bt2020nc"}],"frames":[{}]}`
+	probeHLG = `{"streams":[{"width":1920,"height":1080,"color_transfer":"arib-std-b67","side_data_list":[{"rotation":-90}]}],"frames":[{}]}`
+	probeSDR = `{"streams":[{"width":1920,"height":1080,"color_transfer":"bt709","color_primaries":"bt709","color_space":"bt709"}],"frames":[{}]}`
+)
+
+func probeFixture(t *testing.T, probe string) mediaInfo {
+	t.Helper()
+
+	var output probeOutput
+	if err := json.Unmarshal([]byte(probe), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.mediaInfo()
+}
+
+func TestMediaInfoHDR(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		probe           string
+		wantHDR         bool
+		wantRange       string
+		wantToneMapping string
+	}{
+		"pq": {
+			probePQ,
+			true,
+			"PQ",
+			toneMappingFilter,
+		},
+		"hlg": {
+			probeHLG,
+			true,
+			"HLG",
+			toneMappingFilter,
+		},
+		"sdr": {
+			probeSDR,
+			false,
+			"SDR",
+			"",
+		},
+		"unknown transfer": {
+			`{"streams":[{"width":640,"height":480}]}`,
+			false,
+			"SDR",
+			"",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			info := probeFixture(t, testCase.probe)
+
+			if got := info.isHDR(); got != testCase.wantHDR {
+				t.Errorf("isHDR() = %t, want %t", got, testCase.wantHDR)
+			}
+
+			if got := info.videoRange(); got != testCase.wantRange {
+				t.Errorf("videoRange() = `%s`, want `%s`", got, testCase.wantRange)
+			}
+
+			if got := info.toneMapping(); got != testCase.wantToneMapping {
+				t.Errorf("toneMapping() = `%s`, want `%s`", got, testCase.wantToneMapping)
+			}
+		})
+	}
+}
+
+func TestStreamOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		probe   string
+		wantSdr []string
+		wantHdr []string
+	}{
+		"pq": {
+			probePQ,
+			[]string{"-codec:v", "libx264", "-preset", "superfast", "-metadata:s:v:0", "rotate=0", "-vf", toneMappingFilter, "-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709"},
+			[]string{"-codec:v", "libx265", "-preset", "superfast", "-tag:v", "hvc1", "-metadata:s:v:0", "rotate=0", "-pix_fmt", "yuv420p10le", "-x265-params", "hdr-opt=1:repeat-headers=1", "-color_primaries", "bt2020", "-color_trc", "smpte2084", "-colorspace", "bt2020nc"},
+		},
+		"hlg rotated without primaries": {
+			probeHLG,
+			[]string{"-codec:v", "libx264", "-preset", "superfast", "-metadata:s:v:0", "rotate=0", "-vf", toneMappingFilter + ",transpose=clock", "-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709"},
+			[]string{"-codec:v", "libx265", "-preset", "superfast", "-tag:v", "hvc1", "-metadata:s:v:0", "rotate=0", "-vf", "transpose=clock", "-pix_fmt", "yuv420p10le", "-x265-params", "hdr-opt=1:repeat-headers=1", "-color_primaries", "bt2020", "-color_trc", "arib-std-b67", "-colorspace", "bt2020nc"},
+		},
+		"sdr": {
+			probeSDR,
+			[]string{"-codec:v", "libx264", "-preset", "superfast", "-metadata:s:v:0", "rotate=0"},
+			[]string{"-codec:v", "libx265", "-preset", "superfast", "-tag:v", "hvc1", "-metadata:s:v:0", "rotate=0", "-pix_fmt", "yuv420p10le", "-x265-params", "hdr-opt=1:repeat-headers=1", "-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709"},
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			info := probeFixture(t, testCase.probe)
+
+			if got := streamSdrOptions(info, preparedOverlay{}); !reflect.DeepEqual(got, testCase.wantSdr) {
+				t.Errorf("streamSdrOptions() = %q, want %q", got, testCase.wantSdr)
+			}
+
+			if got := streamHdrOptions(info, preparedOverlay{}); !reflect.DeepEqual(got, testCase.wantHdr) {
+				t.Errorf("streamHdrOptions() = %q, want %q", got, testCase.wantHdr)
+			}
+		})
+	}
+}
+
+func TestHdrStreamName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		name string
+		want string
+	}{
+		"playlist": {
+			"/videos/video.m3u8",
+			"/videos/video_hdr.m3u8",
+		},
+		"dotted": {
+			"/videos/holidays.2024.m3u8",
+			"/videos/holidays.2024_hdr.m3u8",
+		},
+		"other extension": {
+			"/videos/video.mp4",
+			"/videos/video.mp4_hdr.m3u8",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := hdrStreamName(testCase.name); got != testCase.want {
+				t.Errorf("hdrStreamName() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+const (
+	subtitlesGroup = "subs"
+	vttExtension   = ".vtt"
+)
+
+// errUnknownDuration occurs when neither the probe nor the stream playlist give the duration that subtitle playlists require
+var errUnknownDuration = errors.New("unknown duration, required by subtitle playlists")
+
+// textSubtitleCodecs are the subtitle codecs that can be converted to WebVTT, bitmap ones (PGS, VobSub, DVB) can't
+var textSubtitleCodecs = map[string]bool{
+	"ass":      true,
+	"mov_text": true,
+	"ssa":      true,
+	"subrip":   true,
+	"text":     true,
+	"webvtt":   true,
+}
+
+func (mt mediaTrack) isText() bool {
+	return textSubtitleCodecs[mt.Codec]
+}
+
+// extractSubtitles converts every text subtitle track to a WebVTT file alongside its single segment playlist
+func (s Service) extractSubtitles(ctx context.Context, inputName, outputName string, duration float64) ([]hlsRendition, error) {
+	if duration <= 0 {
+		// the probe failed, the generated stream gives the duration too
+		if content, err := os.ReadFile(outputName); err == nil {
+			duration = playlistDuration(content)
+		}
+	}
+
+	if duration <= 0 {
+		return nil, errUnknownDuration
+	}
+
+	tracks, err := s.getTracks(ctx, inputName, "s")
+	if err != nil {
+		return nil, err
+	}
+
+	var renditions []hlsRendition
+
+	for _, track := range tracks {
+		if !track.isText() {
+			slog.LogAttrs(ctx, slog.LevelWarn, "skipping bitmap subtitle", slog.String("input", inputName), slog.Int("index", track.Index), slog.String("codec", track.Codec))
+			continue
+		}
+
+		playlistName := subtitleStreamName(outputName, len(renditions))
+
+		if err := s.extractSubtitle(ctx, inputName, playlistName, track, duration); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "extract subtitle", slog.String("input", inputName), slog.Int("index", track.Index), slog.Any("error", err))
+			continue
+		}
+
+		renditions = append(renditions, hlsRendition{
+			kind:     "SUBTITLES",
+			group:    subtitlesGroup,
+			name:     track.name(),
+			language: track.language(),
+			uri:      filepath.Base(playlistName),
+		})
+	}
+
+	return renditions, nil
+}
+
+func (s Service) extractSubtitle(ctx context.Context, inputName, playlistName string, track mediaTrack, duration float64) (err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_subtitle")
+	defer end(&err)
+
+	vttName := strings.TrimSuffix(playlistName, hlsExtension) + vttExtension
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", inputName, "-map", fmt.Sprintf("0:%d", track.Index), "-codec:s", "webvtt", "-y", "-f", "webvtt", vttName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, vttName)
+		return fmt.Errorf("ffmpeg subtitle: %s: %w", buffer.String(), err)
+	}
+
+	if err = os.WriteFile(playlistName, subtitlePlaylist(filepath.Base(vttName), duration), absto.RegularFilePerm); err != nil {
+		cleanLocalFile(ctx, vttName)
+		return fmt.Errorf("write subtitle playlist: %w", err)
+	}
+
+	return nil
+}
+
+func subtitlePlaylist(vttName string, duration float64) []byte {
+	var playlist strings.Builder
+
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	playlist.WriteString("#EXT-X-TARGETDURATION:" + strconv.FormatFloat(math.Ceil(duration), 'f', 0, 64) + "\n")
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	playlist.WriteString("#EXTINF:" + strconv.FormatFloat(duration, 'f', 3, 64) + ",\n")
+	playlist.WriteString(vttName + "\n")
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	return []byte(playlist.String())
+}
+
+// playlistDuration sums the durations of the segments of a media playlist
+func playlistDuration(content []byte) float64 {
+	var duration float64
+
+	for _, line := range strings.Split(string(content), "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "#EXTINF:")
+		if !ok {
+			continue
+		}
+
+		value, _, _ = strings.Cut(value, ",")
+
+		if segment, err := strconv.ParseFloat(value, 64); err == nil && segment > 0 {
+			duration += segment
+		}
+	}
+
+	return duration
+}
+
+func subtitleStreamName(name string, index int) string {
+	return fmt.Sprintf("%s%s%d%s", strings.TrimSuffix(name, hlsExtension), subtitlesSuffix, index, hlsExtension)
+}
@@ -0,0 +1,186 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubtitlePlaylist(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		vttName  string
+		duration float64
+		want     string
+	}{
+		"rounded up target": {
+			"video_sub0.vtt",
+			62.5,
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:63\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:62.500,\nvideo_sub0.vtt\n#EXT-X-ENDLIST\n",
+		},
+		"whole seconds": {
+			"video_sub1.vtt",
+			120,
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:120\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:120.000,\nvideo_sub1.vtt\n#EXT-X-ENDLIST\n",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := string(subtitlePlaylist(testCase.vttName, testCase.duration)); got != testCase.want {
+				t.Errorf("subtitlePlaylist() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPlaylistDuration(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content string
+		want    float64
+	}{
+		"segments": {
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000000,\nvideo0.ts\n#EXTINF:4.000000,\nvideo1.ts\n#EXTINF:1.500000,\nvideo2.ts\n#EXT-X-ENDLIST\n",
+			9.5,
+		},
+		"titled": {
+			"#EXTM3U\n#EXTINF:2.5,intro\nvideo0.ts\n",
+			2.5,
+		},
+		"invalid": {
+			"#EXTM3U\n#EXTINF:abc,\nvideo0.ts\n#EXTINF:-1,\nvideo1.ts\n",
+			0,
+		},
+		"empty": {
+			"",
+			0,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			if got := playlistDuration([]byte(testCase.content)); got != testCase.want {
+				t.Errorf("playlistDuration() = %g, want %g", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestExtractSubtitlesDuration(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		playlist string
+		duration float64
+		wantErr  bool
+	}{
+		"no duration nor playlist": {
+			"",
+			0,
+			true,
+		},
+		"empty playlist": {
+			"#EXTM3U\n",
+			0,
+			true,
+		},
+		"playlist duration": {
+			"#EXTM3U\n#EXTINF:4.000000,\nvideo0.ts\n",
+			0,
+			false,
+		},
+		"probed duration": {
+			"",
+			12,
+			false,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			outputName := filepath.Join(t.TempDir(), "video.m3u8")
+
+			if len(testCase.playlist) != 0 {
+				if err := os.WriteFile(outputName, []byte(testCase.playlist), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the input doesn't exist, any error after the duration check comes from listing its tracks
+			_, err := Service{}.extractSubtitles(context.Background(), filepath.Join(t.TempDir(), "missing.mp4"), outputName, testCase.duration)
+
+			if got := errors.Is(err, errUnknownDuration); got != testCase.wantErr {
+				t.Errorf("extractSubtitles() error = %v, want unknown duration %t", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		variants   []hlsVariant
+		renditions []hlsRendition
+		want       string
+	}{
+		"subtitles": {
+			[]hlsVariant{{uri: "video.m3u8", videoRange: "SDR", bandwidth: 2_000_000}},
+			[]hlsRendition{
+				{kind: "SUBTITLES", group: subtitlesGroup, name: "English", language: "eng", uri: "video_sub0.m3u8"},
+				{kind: "SUBTITLES", group: subtitlesGroup, name: "Français", language: "fre", uri: "video_sub1.m3u8"},
+			},
+			"#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="eng",DEFAULT=NO,AUTOSELECT=YES,URI="video_sub0.m3u8"` + "\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Français",LANGUAGE="fre",DEFAULT=NO,AUTOSELECT=YES,URI="video_sub1.m3u8"` + "\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=2000000,VIDEO-RANGE=SDR,SUBTITLES="subs"` + "\n" +
+				"video.m3u8\n",
+		},
+		"hdr with audio and subtitles": {
+			[]hlsVariant{{uri: "video.m3u8", videoRange: "SDR"}, {uri: "video_hdr.m3u8", videoRange: "PQ"}},
+			[]hlsRendition{
+				{kind: "AUDIO", group: "audio", name: "Commentary", language: "eng", uri: "video_audio1.m3u8"},
+				{kind: "SUBTITLES", group: subtitlesGroup, name: "English", language: "eng", uri: "video_sub0.m3u8", isDefault: true},
+			},
+			"#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="Commentary",LANGUAGE="eng",DEFAULT=NO,AUTOSELECT=YES,URI="video_audio1.m3u8"` + "\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="eng",DEFAULT=YES,AUTOSELECT=YES,URI="video_sub0.m3u8"` + "\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=2000000,VIDEO-RANGE=SDR,AUDIO="audio",SUBTITLES="subs"` + "\n" +
+				"video.m3u8\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=2000000,VIDEO-RANGE=PQ,AUDIO="audio",SUBTITLES="subs"` + "\n" +
+				"video_hdr.m3u8\n",
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			outputName := filepath.Join(t.TempDir(), "video.m3u8")
+
+			if err := writeMasterPlaylist(outputName, testCase.variants, testCase.renditions); err != nil {
+				t.Fatalf("writeMasterPlaylist() error = %s", err)
+			}
+
+			content, err := os.ReadFile(masterStreamName(outputName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(content); got != testCase.want {
+				t.Errorf("writeMasterPlaylist() = `%s`, want `%s`", got, testCase.want)
+			}
+		})
+	}
+}
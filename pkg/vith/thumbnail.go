@@ -18,7 +18,38 @@ import (
 
 const thumbnailDuration = 5
 
-func (s Service) storageThumbnail(ctx context.Context, itemType model.ItemType, input, output string, scale uint64) (err error) {
+type thumbnailOptions struct {
+	overlay model.Overlay
+	scale   uint64
+	page    uint64
+}
+
+func newThumbnailOptions(scale, page uint64, overlay model.Overlay) thumbnailOptions {
+	if scale == 0 {
+		scale = defaultScale
+	}
+
+	if page == 0 {
+		page = 1
+	}
+
+	return thumbnailOptions{
+		overlay: overlay,
+		scale:   scale,
+		page:    page,
+	}
+}
+
+// thumbnailMetadata holds what is computed alongside a thumbnail
+type thumbnailMetadata struct {
+	hash        *model.Hash
+	placeholder *model.Placeholder
+}
+
+func (s Service) storageThumbnail(ctx context.Context, itemType model.ItemType, input, output string, options thumbnailOptions) (metadata thumbnailMetadata, err error) {
+	ctx, done := s.startJob(ctx, "thumbnail", itemType)
+	defer done()
+
 	if err = s.storage.Mkdir(ctx, path.Dir(output), absto.DirectoryPerm); err != nil {
 		err = fmt.Errorf("create directory for output: %w", err)
 		return
@@ -32,20 +63,60 @@ func (s Service) storageThumbnail(ctx context.Context, itemType model.ItemType,
 		err = fmt.Errorf("get input name: %w", err)
 	} else {
 		outputName, finalizeOutput := s.getOutputName(ctx, output)
-		err = errors.Join(s.getThumbnailGenerator(itemType)(ctx, inputName, outputName, scale), finalizeOutput())
+
+		if err = s.getThumbnailGenerator(itemType)(ctx, inputName, outputName, options); err == nil {
+			s.recordInputSize(ctx, inputName)
+			s.recordOutputSize(ctx, outputName)
+
+			metadata = s.thumbnailMetadata(ctx, itemType, inputName, outputName)
+		}
+
+		err = errors.Join(err, finalizeOutput())
 		finalizeInput()
 	}
 
-	return err
+	return
+}
+
+// thumbnailMetadata computes the perceptual hash of the input, if enabled, and the placeholder of its thumbnail, failures are only logged
+func (s Service) thumbnailMetadata(ctx context.Context, itemType model.ItemType, inputName, outputName string) (metadata thumbnailMetadata) {
+	if s.hashEnabled && isHashable(itemType) {
+		if hash, err := s.perceptualHash(ctx, itemType, inputName); err != nil {
+			slog.LogAttrs(ctx, slog.LevelError, "perceptual hash", slog.String("input", inputName), slog.Any("error", err))
+		} else {
+			metadata.hash = &hash
+		}
+	}
+
+	if placeholder, err := s.placeholder(ctx, outputName); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "placeholder", slog.String("input", inputName), slog.Any("error", err))
+	} else {
+		metadata.placeholder = &placeholder
+	}
+
+	return metadata
 }
 
-func (s Service) imageThumbnail(ctx context.Context, inputName, outputName string, scale uint64) error {
+func (s Service) ffmpegImageThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) error {
 	var err error
 
+	scale := options.scale
+
 	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_thumbnail")
 	defer end(&err)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-hwaccel", "auto", "-i", inputName, "-map_metadata", "-1", "-vf", fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale=%d:%d", scale, scale), "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(scale), "-an", "-preset", "picture", "-y", "-f", "webp", "-frames:v", "1", outputName)
+	info, infoErr := s.getMediaInfo(ctx, inputName)
+	if infoErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get image info", slog.String("input", inputName), slog.Any("error", infoErr))
+	}
+
+	overlay, cleanOverlay, err := s.prepareOverlay(ctx, options.overlay)
+	if err != nil {
+		return fmt.Errorf("prepare overlay: %w", err)
+	}
+	defer cleanOverlay()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hwaccel", "auto", "-noautorotate", "-i", inputName, "-map_metadata", "-1", "-vf", overlay.apply(thumbnailFilters(info, scale)), "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(scale), "-an", "-preset", "picture", "-y", "-f", "webp", "-frames:v", "1", outputName)
 
 	buffer := bufferPool.Get().(*bytes.Buffer)
 	defer bufferPool.Put(buffer)
@@ -54,7 +125,7 @@ func (s Service) imageThumbnail(ctx context.Context, inputName, outputName strin
 	cmd.Stdout = buffer
 	cmd.Stderr = buffer
 
-	if err = cmd.Run(); err != nil {
+	if err = s.runCommand(ctx, cmd); err != nil {
 		cleanLocalFile(ctx, outputName)
 		return fmt.Errorf("ffmpeg image: %s: %w", buffer.String(), err)
 	}
@@ -62,9 +133,11 @@ func (s Service) imageThumbnail(ctx context.Context, inputName, outputName strin
 	return nil
 }
 
-func (s Service) videoThumbnail(ctx context.Context, inputName, outputName string, scale uint64) error {
+func (s Service) videoThumbnail(ctx context.Context, inputName, outputName string, options thumbnailOptions) error {
 	var err error
 
+	scale := options.scale
+
 	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffmpeg_video_thumbnail")
 	defer end(&err)
 
@@ -83,7 +156,18 @@ func (s Service) videoThumbnail(ctx context.Context, inputName, outputName strin
 		ffmpegOpts = append(ffmpegOpts, "-ss", fmt.Sprintf("%.3f", startPoint))
 	}
 
-	format := fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale=%d:%d", scale, scale)
+	info, infoErr := s.getMediaInfo(ctx, inputName)
+	if infoErr != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video info", slog.String("input", inputName), slog.Any("error", infoErr))
+	}
+
+	overlay, cleanOverlay, err := s.prepareOverlay(ctx, options.overlay)
+	if err != nil {
+		return fmt.Errorf("prepare overlay: %w", err)
+	}
+	defer cleanOverlay()
+
+	format := overlay.apply(joinFilters(info.toneMapping(), thumbnailFilters(info, scale)))
 	if scale == SmallSize {
 		ffmpegOpts = append(ffmpegOpts, "-t", strconv.Itoa(thumbnailDuration))
 		customOpts = []string{"-r", "8", "-loop", "0"}
@@ -91,7 +175,7 @@ func (s Service) videoThumbnail(ctx context.Context, inputName, outputName strin
 		customOpts = []string{"-frames:v", "1"}
 	}
 
-	ffmpegOpts = append(ffmpegOpts, "-i", inputName, "-map_metadata", "-1", "-vf", format, "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(scale), "-an", "-preset", "picture", "-y", "-f", "webp")
+	ffmpegOpts = append(ffmpegOpts, "-noautorotate", "-i", inputName, "-map_metadata", "-1", "-vf", format, "-vcodec", "libwebp", "-lossless", "0", "-compression_level", "6", "-q:v", qualityForScale(scale), "-an", "-preset", "picture", "-y", "-f", "webp")
 	ffmpegOpts = append(ffmpegOpts, customOpts...)
 	ffmpegOpts = append(ffmpegOpts, outputName)
 	cmd := exec.Command("ffmpeg", ffmpegOpts...)
@@ -103,7 +187,7 @@ func (s Service) videoThumbnail(ctx context.Context, inputName, outputName strin
 	cmd.Stdout = buffer
 	cmd.Stderr = buffer
 
-	if err = cmd.Run(); err != nil {
+	if err = s.runCommand(ctx, cmd); err != nil {
 		cleanLocalFile(ctx, outputName)
 		return fmt.Errorf("ffmpeg video: %s: %w", buffer.String(), err)
 	}
@@ -121,6 +205,10 @@ func (s Service) getVideoDetailsFromLocal(ctx context.Context, name string) (int
 	return s.getVideoDetails(ctx, name)
 }
 
+func thumbnailFilters(info mediaInfo, scale uint64) string {
+	return joinFilters(info.orientation(), fmt.Sprintf("crop='min(iw,ih)':'min(iw,ih)',scale=%d:%d", scale, scale))
+}
+
 func qualityForScale(scale uint64) string {
 	if scale == SmallSize {
 		return "66"
@@ -0,0 +1,119 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const tmpNamespace = "vith"
+
+// processTmpFolder creates the temporary folder of this process, inside the vith namespace of the root folder
+func processTmpFolder(root string) (string, error) {
+	namespace := filepath.Join(root, tmpNamespace)
+
+	if err := os.MkdirAll(namespace, 0o700); err != nil {
+		return "", err
+	}
+
+	return os.MkdirTemp(namespace, "process-")
+}
+
+// SweepTmp removes the temporary files older than the max age at startup, then on every interval, until the context is done
+func (s Service) SweepTmp(ctx context.Context) {
+	if s.tmpMaxAge <= 0 {
+		return
+	}
+
+	s.sweepTmp(ctx)
+
+	if s.tmpSweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.tmpSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweepTmp(ctx)
+		}
+	}
+}
+
+// sweepTmp removes the old files of every process, because a crashed one never cleans its own, and the empty folders of other processes
+func (s Service) sweepTmp(ctx context.Context) {
+	namespace := filepath.Join(s.tmpRoot, tmpNamespace)
+	now := time.Now()
+	threshold := now.Add(-s.tmpMaxAge)
+
+	// other processes sharing the root folder rely on the modification time of our folder to know it's still in use
+	if err := os.Chtimes(s.tmpFolder, now, now); err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "touch process temporary folder", slog.String("folder", s.tmpFolder), slog.Any("error", err))
+	}
+
+	var files, reclaimed int64
+	var folders []string
+
+	err := filepath.WalkDir(namespace, func(pathname string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if entry.IsDir() {
+			if pathname != namespace && pathname != s.tmpFolder && info.ModTime().Before(threshold) {
+				folders = append(folders, pathname)
+			}
+
+			return nil
+		}
+
+		if info.ModTime().After(threshold) {
+			return nil
+		}
+
+		if err := os.Remove(pathname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.LogAttrs(ctx, slog.LevelError, "remove temporary file", slog.String("name", pathname), slog.Any("error", err))
+			return nil
+		}
+
+		files++
+		reclaimed += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "sweep temporary files", slog.String("folder", namespace), slog.Any("error", err))
+	}
+
+	// deepest folders first, a folder that is still not empty is in use and kept
+	for i := len(folders) - 1; i >= 0; i-- {
+		_ = os.Remove(folders[i])
+	}
+
+	if files != 0 {
+		slog.LogAttrs(ctx, slog.LevelInfo, "Temporary files swept", slog.Int64("files", files), slog.Int64("bytes", reclaimed))
+	}
+
+	s.recordSweep(ctx, files, reclaimed)
+}
@@ -0,0 +1,168 @@
+package vith
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func agedFile(t *testing.T, name, content string, age time.Duration) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ageFile(t, name, age)
+}
+
+func ageFile(t *testing.T, name string, age time.Duration) {
+	t.Helper()
+
+	modTime := time.Now().Add(-age)
+
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweepTmp(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	namespace := filepath.Join(root, tmpNamespace)
+
+	own := filepath.Join(namespace, "process-own")
+	crashed := filepath.Join(namespace, "process-crashed")
+	busy := filepath.Join(namespace, "process-busy")
+	live := filepath.Join(namespace, "process-live")
+
+	agedFile(t, filepath.Join(own, "old.mp4"), "own", 48*time.Hour)
+	agedFile(t, filepath.Join(own, "recent.mp4"), "recent", 0)
+	agedFile(t, filepath.Join(crashed, "input.mp4"), "crashed", 48*time.Hour)
+	agedFile(t, filepath.Join(crashed, "stream", "segment.ts"), "segment", 48*time.Hour)
+	agedFile(t, filepath.Join(busy, "output.webp"), "busy", time.Hour)
+	agedFile(t, filepath.Join(busy, "stream", "segment.ts"), "old", 48*time.Hour)
+
+	if err := os.MkdirAll(live, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// folders are aged last, writing their files updated them
+	for _, folder := range []string{own, filepath.Join(crashed, "stream"), crashed, filepath.Join(busy, "stream"), busy} {
+		ageFile(t, folder, 48*time.Hour)
+	}
+
+	service, reader := metricService(t)
+	service.tmpRoot = root
+	service.tmpFolder = own
+	service.tmpMaxAge = 24 * time.Hour
+
+	service.sweepTmp(context.Background())
+
+	cases := map[string]struct {
+		name   string
+		exists bool
+	}{
+		"own old file": {
+			filepath.Join(own, "old.mp4"),
+			false,
+		},
+		"own recent file": {
+			filepath.Join(own, "recent.mp4"),
+			true,
+		},
+		"crashed file": {
+			filepath.Join(crashed, "input.mp4"),
+			false,
+		},
+		"crashed folder": {
+			crashed,
+			false,
+		},
+		"busy recent file": {
+			filepath.Join(busy, "output.webp"),
+			true,
+		},
+		"busy old subfolder": {
+			filepath.Join(busy, "stream"),
+			false,
+		},
+		"busy folder": {
+			busy,
+			true,
+		},
+		"live empty folder": {
+			live,
+			true,
+		},
+	}
+
+	for intention, testCase := range cases {
+		t.Run(intention, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := os.Stat(testCase.name)
+			if got := !errors.Is(err, fs.ErrNotExist); got != testCase.exists {
+				t.Errorf("sweepTmp() kept `%s` = %t, want %t", testCase.name, got, testCase.exists)
+			}
+		})
+	}
+
+	info, err := os.Stat(own)
+	if err != nil {
+		t.Fatalf("sweepTmp() removed the process folder: %s", err)
+	}
+
+	if time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("sweepTmp() process folder modified at %s, want it touched", info.ModTime())
+	}
+
+	removed, _ := collectMetric(t, reader, "vith.tmp.removed").(metricdata.Sum[int64])
+	if len(removed.DataPoints) != 1 || removed.DataPoints[0].Value != 4 {
+		t.Errorf("vith.tmp.removed = %+v, want 4", removed.DataPoints)
+	}
+
+	reclaimed, _ := collectMetric(t, reader, "vith.tmp.reclaimed").(metricdata.Sum[int64])
+	if len(reclaimed.DataPoints) != 1 || reclaimed.DataPoints[0].Value != int64(len("own")+len("crashed")+len("segment")+len("old")) {
+		t.Errorf("vith.tmp.reclaimed = %+v, want %d", reclaimed.DataPoints, len("own")+len("crashed")+len("segment")+len("old"))
+	}
+}
+
+func TestSweepTmpDisabled(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	own := filepath.Join(root, tmpNamespace, "process-own")
+	leftover := filepath.Join(root, tmpNamespace, "process-crashed", "input.mp4")
+
+	agedFile(t, leftover, "crashed", 365*24*time.Hour)
+
+	if err := os.MkdirAll(own, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// returns straight away instead of ticking until the context is done
+	Service{tmpRoot: root, tmpFolder: own, tmpSweepInterval: time.Millisecond}.SweepTmp(ctx)
+
+	if ctx.Err() != nil {
+		t.Error("SweepTmp() ran until the context was done, want an immediate return")
+	}
+
+	if _, err := os.Stat(leftover); err != nil {
+		t.Errorf("SweepTmp() removed `%s` with a zero max age: %s", leftover, err)
+	}
+}
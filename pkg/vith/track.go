@@ -0,0 +1,78 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+)
+
+const undefinedLanguage = "und"
+
+type mediaTrack struct {
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Index    int    `json:"index"`
+}
+
+func (mt mediaTrack) language() string {
+	if len(mt.Language) == 0 {
+		return undefinedLanguage
+	}
+
+	return mt.Language
+}
+
+func (mt mediaTrack) name() string {
+	if len(mt.Title) != 0 {
+		return mt.Title
+	}
+
+	return mt.language()
+}
+
+type trackProbeOutput struct {
+	Streams []struct {
+		Tags      map[string]string `json:"tags"`
+		CodecName string            `json:"codec_name"`
+		Index     int               `json:"index"`
+	} `json:"streams"`
+}
+
+// getTracks lists the streams matching the ffprobe stream specifier, e.g. `a` for audio or `s` for subtitles
+func (s Service) getTracks(ctx context.Context, inputName, specifier string) (tracks []mediaTrack, err error) {
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "ffprobe_tracks")
+	defer end(&err)
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", specifier, "-show_entries", "stream=index,codec_name:stream_tags=language,title", "-of", "json", inputName)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("ffprobe tracks: %w", err)
+	}
+
+	var output trackProbeOutput
+	if err = json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("parse ffprobe output: %w", err)
+	}
+
+	for _, stream := range output.Streams {
+		tracks = append(tracks, mediaTrack{
+			Index:    stream.Index,
+			Codec:    stream.CodecName,
+			Language: stream.Tags["language"],
+			Title:    stream.Tags["title"],
+		})
+	}
+
+	return tracks, nil
+}
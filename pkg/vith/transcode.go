@@ -0,0 +1,166 @@
+package vith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	absto "github.com/ViBiOh/absto/pkg/model"
+	"github.com/ViBiOh/absto/pkg/s3"
+	"github.com/ViBiOh/httputils/v4/pkg/telemetry"
+	"github.com/ViBiOh/vith/pkg/model"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func (s Service) AmqpTranscodeHandler(ctx context.Context, message amqp.Delivery) error {
+	if !s.storage.Enabled() {
+		return errors.New("vith has no direct access to filesystem")
+	}
+
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "amqp")
+	defer end(&err)
+
+	var req model.Request
+	if err = json.Unmarshal(message.Body, &req); err != nil {
+		s.increaseMetric(ctx, "amqp", "transcode", "", "invalid")
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	if req.ItemType != model.TypeVideo {
+		s.increaseMetric(ctx, "amqp", "transcode", req.ItemType.String(), "forbidden")
+		return errors.New("transcode are possible for video type only")
+	}
+
+	if len(req.Input) == 0 {
+		s.increaseMetric(ctx, "amqp", "transcode", req.ItemType.String(), "input_invalid")
+		return errors.New("input is mandatory")
+	}
+
+	if filepath.Ext(req.Output) != mp4Extension {
+		s.increaseMetric(ctx, "amqp", "transcode", req.ItemType.String(), "output_invalid")
+		return fmt.Errorf("output must be a `%s` file", mp4Extension)
+	}
+
+	req.Mode = model.ModeTranscode
+
+	slog.LogAttrs(ctx, slog.LevelInfo, "Adding transcode generation in the work queue", slog.String("input", req.Input), slog.String("output", req.Output))
+
+	if !s.enqueue(req) {
+		s.increaseMetric(ctx, "amqp", "transcode", req.ItemType.String(), "error")
+		return errors.New("service is stopping")
+	}
+
+	s.increaseMetric(ctx, "amqp", "transcode", req.ItemType.String(), "queued")
+
+	return nil
+}
+
+func (s Service) generateTranscode(ctx context.Context, req model.Request) error {
+	var err error
+
+	ctx, end := telemetry.StartSpan(ctx, s.tracer, "transcode")
+	defer end(&err)
+
+	ctx, done := s.startJob(ctx, "transcode", req.ItemType)
+	defer done()
+
+	if err = s.requireFeature("transcode"); err != nil {
+		return err
+	}
+
+	log := slog.With("input", req.Input).With("output", req.Output)
+	log.InfoContext(ctx, "Generating transcode...")
+
+	if err = s.storage.Mkdir(ctx, path.Dir(req.Output), absto.DirectoryPerm); err != nil {
+		return fmt.Errorf("create directory for output: %w", err)
+	}
+
+	inputName, finalizeInput, err := s.getInputName(ctx, req.Input)
+	if err != nil {
+		return fmt.Errorf("get input video name: %w", err)
+	}
+	defer finalizeInput()
+
+	if s.storage.Name() == s3.Name {
+		// a transcode is capped in height and bitrate, it's not expected to be larger than its input
+		release, err := s.reserveTmp(ctx, fileSize(inputName))
+		if err != nil {
+			return fmt.Errorf("reserve transcode space: %w", err)
+		}
+		defer release()
+	}
+
+	outputName, finalizeOutput := s.getOutputName(ctx, req.Output)
+
+	if err = s.runTranscode(ctx, inputName, outputName, s.transcodeHeightOrDefault(req.Height), s.transcodeBitrateOrDefault(req.Bitrate)); err == nil {
+		s.recordInputSize(ctx, inputName)
+		s.recordOutputSize(ctx, outputName)
+	}
+
+	if err = errors.Join(err, finalizeOutput()); err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "Transcode succeeded!")
+
+	return nil
+}
+
+func (s Service) runTranscode(ctx context.Context, inputName, outputName string, height, bitrate uint64) error {
+	info, err := s.getMediaInfo(ctx, inputName)
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "get video info", slog.String("input", inputName), slog.Any("error", err))
+	}
+
+	filters := joinFilters(info.toneMapping(), info.orientation(), fmt.Sprintf("scale=-2:'min(ih,%d)'", height))
+
+	ffmpegOpts := []string{"-hwaccel", "auto", "-noautorotate", "-i", inputName, "-map", "0:v:0", "-map", "0:a:0?", "-vf", filters, "-codec:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-metadata:s:v:0", "rotate=0"}
+
+	if bitrate > 0 {
+		ffmpegOpts = append(ffmpegOpts, "-b:v", fmt.Sprintf("%dk", bitrate), "-maxrate", fmt.Sprintf("%dk", bitrate), "-bufsize", fmt.Sprintf("%dk", 2*bitrate))
+	} else {
+		ffmpegOpts = append(ffmpegOpts, "-crf", "23")
+	}
+
+	ffmpegOpts = append(ffmpegOpts, "-codec:a", "aac", "-b:a", "128k", "-ac", "2", "-movflags", "+faststart", "-threads", "2", "-y", "-f", mp4Format, outputName)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegOpts...)
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buffer)
+
+	buffer.Reset()
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	if err = s.runCommand(ctx, cmd); err != nil {
+		cleanLocalFile(ctx, outputName)
+		return fmt.Errorf("ffmpeg transcode: %s: %w", buffer.String(), err)
+	}
+
+	return nil
+}
+
+func (s Service) transcodeHeightOrDefault(height uint64) uint64 {
+	if height == 0 || height > s.transcodeHeight {
+		return s.transcodeHeight
+	}
+
+	return height
+}
+
+func (s Service) transcodeBitrateOrDefault(bitrate uint64) uint64 {
+	if bitrate == 0 || (s.transcodeBitrate != 0 && bitrate > s.transcodeBitrate) {
+		return s.transcodeBitrate
+	}
+
+	return bitrate
+}
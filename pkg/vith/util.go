@@ -24,14 +24,24 @@ var noErrFunc = func() error {
 	return nil
 }
 
-func (s Service) getThumbnailGenerator(itemType model.ItemType) func(context.Context, string, string, uint64) error {
+func (s Service) getThumbnailGenerator(itemType model.ItemType) func(context.Context, string, string, thumbnailOptions) error {
+	if err := s.requireFeature("thumbnail"); err != nil {
+		return func(_ context.Context, _, _ string, _ thumbnailOptions) error {
+			return err
+		}
+	}
+
 	switch itemType {
 	case model.TypeVideo:
 		return s.videoThumbnail
 	case model.TypeImage:
 		return s.imageThumbnail
+	case model.TypeAudio:
+		return s.audioThumbnail
+	case model.TypeDocument:
+		return s.documentThumbnail
 	default:
-		return func(_ context.Context, _, _ string, _ uint64) error {
+		return func(_ context.Context, _, _ string, _ thumbnailOptions) error {
 			return fmt.Errorf("unknown generator for `%s`", itemType)
 		}
 	}
@@ -43,19 +53,34 @@ func (s Service) getInputName(ctx context.Context, name string) (string, func(),
 		return s.storage.Path(name), noopFunc, nil
 
 	case s3.Name:
+		item, err := s.storage.Stat(ctx, name)
+		if err != nil {
+			return "", noopFunc, fmt.Errorf("stat from storage: %w", err)
+		}
+
+		release, err := s.reserveTmp(ctx, uint64(max(item.Size(), 0)))
+		if err != nil {
+			return "", noopFunc, fmt.Errorf("reserve input space: %w", err)
+		}
+
 		var reader io.ReadCloser
-		reader, err := s.storage.ReadFrom(ctx, name)
+		reader, err = s.storage.ReadFrom(ctx, name)
 		if err != nil {
+			release()
 			return "", noopFunc, fmt.Errorf("read from storage: %w", err)
 		}
 
 		localName, err := s.saveFileLocally(ctx, reader, fmt.Sprintf("input_%s", name))
 		if err != nil {
 			cleanLocalFile(ctx, localName)
+			release()
 			return "", noopFunc, fmt.Errorf("save file locally: %w", err)
 		}
 
-		return localName, func() { cleanLocalFile(ctx, localName) }, nil
+		return localName, func() {
+			cleanLocalFile(ctx, localName)
+			release()
+		}, nil
 
 	default:
 		return "", noopFunc, errors.New("unknown storage provider")
@@ -99,6 +124,22 @@ func (s Service) saveFileLocally(ctx context.Context, input io.ReadCloser, name
 	return outputName, err
 }
 
+// saveUniqueFile writes the input to a new file of the temporary folder, its name unique to the caller even when jobs share the content
+func (s Service) saveUniqueFile(ctx context.Context, input io.Reader, pattern string) (string, error) {
+	writer, err := os.CreateTemp(s.tmpFolder, pattern)
+	if err != nil {
+		return "", fmt.Errorf("create file: %w", err)
+	}
+
+	_, err = io.Copy(writer, input)
+	if err = errors.Join(err, writer.Close()); err != nil {
+		cleanLocalFile(ctx, writer.Name())
+		return "", fmt.Errorf("write file: %w", err)
+	}
+
+	return writer.Name(), nil
+}
+
 func (s Service) copyAndCloseLocalFile(ctx context.Context, src, target string) error {
 	info, err := os.Stat(src)
 	if err != nil {
@@ -5,7 +5,9 @@ import (
 	"context"
 	"flag"
 	"log/slog"
+	"net/http"
 	"sync"
+	"time"
 
 	absto "github.com/ViBiOh/absto/pkg/model"
 	"github.com/ViBiOh/flags"
@@ -18,7 +20,14 @@ import (
 const (
 	SmallSize = 150
 
-	hlsExtension = ".m3u8"
+	hlsExtension    = ".m3u8"
+	hdrSuffix       = "_hdr"
+	masterSuffix    = "_master"
+	subtitlesSuffix = "_sub"
+	audioSuffix     = "_audio"
+
+	// streamFilesSuffix matches every file of a stream when appended to its name without extension: manifests, renditions and segments
+	streamFilesSuffix = `(_hdr|_master|_sub[0-9]+|_audio[0-9]+)?([0-9]+\.ts|\.vtt|\.m3u8|_key[0-9]+\.key)$`
 )
 
 var bufferPool = sync.Pool{
@@ -28,18 +37,92 @@ var bufferPool = sync.Pool{
 }
 
 type Config struct {
-	TmpFolder string
+	TmpFolder        string
+	TmpMaxAge        time.Duration
+	TmpSweepInterval time.Duration
+	TmpBudget        uint64
+	TmpBudgetWait    time.Duration
+	TmpBodySize      uint64
+
+	CacheFolder  string
+	CacheStorage string
+	CacheSize    uint64
+
+	PipeImages      bool
+	NativeImageSize uint64
+
+	PerceptualHash bool
+
+	RemoteSchemes      []string
+	RemoteMaxSize      uint64
+	RemoteTimeout      time.Duration
+	RemoteMaxRedirects uint
+	RemoteAllowPrivate bool
+
+	StreamAudio         string
+	StreamAudioLanguage string
+
+	StreamHdr bool
+
+	StreamEncryption  bool
+	StreamKeyURI      string
+	StreamKeyFolder   string
+	StreamKeyRotation uint64
+
+	TranscodeHeight  uint64
+	TranscodeBitrate uint64
+
+	WatermarkImage    string
+	WatermarkText     string
+	WatermarkPosition string
+	WatermarkFont     string
+	WatermarkOpacity  float64
+	WatermarkScale    float64
 
 	AmqpExchange   string
 	AmqpRoutingKey string
+
+	AmqpPreviewRoutingKey string
 }
 
 func Flags(fs *flag.FlagSet, prefix string, overrides ...flags.Override) *Config {
 	var config Config
 
 	flags.New("TmpFolder", "Folder used for temporary files storage").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.TmpFolder, "/tmp", overrides)
+	flags.New("TmpMaxAge", "Age after which temporary files are considered leftovers and removed, 0 to disable").Prefix(prefix).DocPrefix("vith").DurationVar(fs, &config.TmpMaxAge, 24*time.Hour, overrides)
+	flags.New("TmpSweepInterval", "Interval between sweeps of leftover temporary files, 0 for startup only").Prefix(prefix).DocPrefix("vith").DurationVar(fs, &config.TmpSweepInterval, time.Hour, overrides)
+	flags.New("TmpBudget", "Temporary disk space that running jobs can reserve, in MiB, 0 for the free space of the folder only").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.TmpBudget, 0, overrides)
+	flags.New("TmpBudgetWait", "Time a job waits for temporary disk space before being rejected").Prefix(prefix).DocPrefix("vith").DurationVar(fs, &config.TmpBudgetWait, time.Minute, overrides)
+	flags.New("TmpBodySize", "Temporary disk space reserved for a request body of unknown length, that is limited to it, in MiB, 0 to refuse them").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.TmpBodySize, 100, overrides)
+	flags.New("CacheFolder", "Local folder of the POST thumbnails cache, disabled if empty").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.CacheFolder, "", overrides)
+	flags.New("CacheSize", "Maximum size of the local thumbnails cache, in MiB, least recently used are evicted").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.CacheSize, 512, overrides)
+	flags.New("CacheStorage", "Storage folder of the POST thumbnails cache, used instead of the local folder").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.CacheStorage, "", overrides)
+	flags.New("PipeImages", "Stream POST images through ffmpeg stdin and stdout when their format needs no seeking, without cache, ETag nor placeholder headers").Prefix(prefix).DocPrefix("vith").BoolVar(fs, &config.PipeImages, false, overrides)
+	flags.New("NativeImageSize", "Maximum size of JPEG, PNG, GIF and WebP images decoded and resampled in process instead of ffmpeg, in MiB, 0 to disable").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.NativeImageSize, 10, overrides)
+	flags.New("PerceptualHash", "Compute perceptual hashes of images and videos, on demand and alongside AMQP thumbnails").Prefix(prefix).DocPrefix("vith").BoolVar(fs, &config.PerceptualHash, false, overrides)
+	flags.New("RemoteSchemes", "URL schemes allowed for thumbnails of remote media, disabled if empty").Prefix(prefix).DocPrefix("vith").StringSliceVar(fs, &config.RemoteSchemes, nil, overrides)
+	flags.New("RemoteMaxSize", "Maximum size of remote media, in MiB").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.RemoteMaxSize, 100, overrides)
+	flags.New("RemoteTimeout", "Timeout of remote media download").Prefix(prefix).DocPrefix("vith").DurationVar(fs, &config.RemoteTimeout, 30*time.Second, overrides)
+	flags.New("RemoteMaxRedirects", "Maximum redirects followed for remote media").Prefix(prefix).DocPrefix("vith").UintVar(fs, &config.RemoteMaxRedirects, 3, overrides)
+	flags.New("RemoteAllowPrivate", "Allow remote media on loopback, private and link-local addresses").Prefix(prefix).DocPrefix("vith").BoolVar(fs, &config.RemoteAllowPrivate, false, overrides)
+	flags.New("StreamAudio", "Audio tracks of video streams: first (default track only), language (preferred language track) or all (one rendition per track)").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.StreamAudio, audioPolicyFirst, overrides)
+	flags.New("StreamAudioLanguage", "Preferred audio language of video streams, as ISO 639-2 code").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.StreamAudioLanguage, "", overrides)
+	flags.New("StreamHdr", "Generate an additional HEVC rendition preserving HDR for HDR videos").Prefix(prefix).DocPrefix("vith").BoolVar(fs, &config.StreamHdr, false, overrides)
+	flags.New("StreamEncryption", "Encrypt HLS segments with AES-128").Prefix(prefix).DocPrefix("vith").BoolVar(fs, &config.StreamEncryption, false, overrides)
+	flags.New("StreamKeyURI", "Key URI written in playlists, `{key}` is replaced by the key filename").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.StreamKeyURI, defaultKeyURI, overrides)
+	flags.New("StreamKeyFolder", "Storage folder where keys are moved, mirroring the stream path, required with encryption unless streamKeyURI points elsewhere").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.StreamKeyFolder, "", overrides)
+	flags.New("StreamKeyRotation", "Rotate encryption key every N segments, 0 for a single key").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.StreamKeyRotation, 0, overrides)
+	flags.New("TranscodeHeight", "Maximum height of MP4 transcode").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.TranscodeHeight, 1080, overrides)
+	flags.New("TranscodeBitrate", "Maximum video bitrate of MP4 transcode in kbps, 0 for quality based encoding").Prefix(prefix).DocPrefix("vith").Uint64Var(fs, &config.TranscodeBitrate, 0, overrides)
+	flags.New("WatermarkImage", "Storage path of the default image watermark").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.WatermarkImage, "", overrides)
+	flags.New("WatermarkText", "Default text watermark").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.WatermarkText, "", overrides)
+	flags.New("WatermarkPosition", "Watermark position: top-left, top-right, bottom-left, bottom-right or center").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.WatermarkPosition, defaultOverlayPosition, overrides)
+	flags.New("WatermarkFont", "Font file used for text watermark, fontconfig default if empty").Prefix(prefix).DocPrefix("vith").StringVar(fs, &config.WatermarkFont, "", overrides)
+	flags.New("WatermarkOpacity", "Watermark opacity, between 0 and 1").Prefix(prefix).DocPrefix("vith").Float64Var(fs, &config.WatermarkOpacity, 0.5, overrides)
+	flags.New("WatermarkScale", "Watermark size relative to output size, between 0 and 1").Prefix(prefix).DocPrefix("vith").Float64Var(fs, &config.WatermarkScale, 0.1, overrides)
 	flags.New("Exchange", "AMQP Exchange Name").Prefix(prefix).DocPrefix("thumbnail").StringVar(fs, &config.AmqpExchange, "fibr", overrides)
 	flags.New("RoutingKey", "AMQP Routing Key to fibr").Prefix(prefix).DocPrefix("thumbnail").StringVar(fs, &config.AmqpRoutingKey, "thumbnail_output", overrides)
+	flags.New("PreviewOutputRoutingKey", "AMQP Routing Key of previews to fibr").Prefix(prefix).DocPrefix("preview").StringVar(fs, &config.AmqpPreviewRoutingKey, "preview_output", overrides)
 
 	return &config
 }
@@ -47,44 +130,132 @@ func Flags(fs *flag.FlagSet, prefix string, overrides ...flags.Override) *Config
 type Service struct {
 	done               chan struct{}
 	stop               chan struct{}
-	streamRequestQueue chan model.Request
+	streamRequestQueue chan queuedRequest
+	overlay            model.Overlay
+	capabilities       Capabilities
 	storage            absto.Storage
 	tracer             trace.Tracer
 	amqpClient         *amqp.Client
-	metric             metric.Int64Counter
+	tmpBudget          *tmpBudget
+	cache              thumbnailCache
+	remoteClient       *http.Client
+	remoteSchemes      []string
+	remoteMaxSize      int64
+	metrics            metrics
 	tmpFolder          string
+	tmpRoot            string
+	overlayFont        string
+	audioPolicy        string
+	audioLanguage      string
+	keyURI             string
+	keyFolder          string
+	keyRotation        uint64
 	amqpExchange       string
 	amqpRoutingKey     string
+	amqpPreviewKey     string
+	transcodeHeight    uint64
+	transcodeBitrate   uint64
+	nativeImageSize    uint64
+	tmpMaxAge          time.Duration
+	tmpSweepInterval   time.Duration
+	streamHdr          bool
+	streamEncryption   bool
+	pipeImages         bool
+	hashEnabled        bool
 }
 
-func New(config *Config, amqpClient *amqp.Client, storageService absto.Storage, meterProvider metric.MeterProvider, tracerProvider trace.TracerProvider) Service {
+func New(config *Config, capabilities Capabilities, amqpClient *amqp.Client, storageService absto.Storage, meterProvider metric.MeterProvider, tracerProvider trace.TracerProvider) (Service, error) {
+	if err := validateAudioPolicy(config.StreamAudio, config.StreamAudioLanguage); err != nil {
+		return Service{}, err
+	}
+
 	service := Service{
-		tmpFolder: config.TmpFolder,
+		tmpFolder:        config.TmpFolder,
+		tmpRoot:          config.TmpFolder,
+		tmpMaxAge:        config.TmpMaxAge,
+		tmpSweepInterval: config.TmpSweepInterval,
+
+		pipeImages:      config.PipeImages,
+		nativeImageSize: config.NativeImageSize * 1024 * 1024,
+		hashEnabled:     config.PerceptualHash,
+
+		capabilities: capabilities,
+
 		storage:   storageService,
+		streamHdr: config.StreamHdr,
+
+		audioPolicy:   config.StreamAudio,
+		audioLanguage: config.StreamAudioLanguage,
+
+		streamEncryption: config.StreamEncryption,
+		keyURI:           config.StreamKeyURI,
+		keyFolder:        config.StreamKeyFolder,
+		keyRotation:      config.StreamKeyRotation,
+
+		transcodeHeight:  config.TranscodeHeight,
+		transcodeBitrate: config.TranscodeBitrate,
+
+		overlay: model.Overlay{
+			Image:    config.WatermarkImage,
+			Text:     config.WatermarkText,
+			Position: config.WatermarkPosition,
+			Opacity:  config.WatermarkOpacity,
+			Scale:    config.WatermarkScale,
+		},
+		overlayFont: config.WatermarkFont,
 
 		amqpClient:     amqpClient,
 		amqpExchange:   config.AmqpExchange,
 		amqpRoutingKey: config.AmqpRoutingKey,
+		amqpPreviewKey: config.AmqpPreviewRoutingKey,
 
-		streamRequestQueue: make(chan model.Request, 4),
+		streamRequestQueue: make(chan queuedRequest, 4),
 		stop:               make(chan struct{}),
 		done:               make(chan struct{}),
 	}
 
-	if meterProvider != nil {
-		meter := meterProvider.Meter("github.com/ViBiOh/vith/pkg/vith")
+	if service.streamHdr && !capabilities.available("stream_hdr") {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "HDR rendition of streams disabled, ffmpeg has no libx265 encoder")
+		service.streamHdr = false
+	}
+
+	if service.streamEncryption && !service.hasKeyLocation() {
+		slog.LogAttrs(context.Background(), slog.LevelError, "Stream encryption has no key location, streams will fail", slog.Any("error", errKeyLocation))
+	}
 
-		var err error
+	if tmpFolder, err := processTmpFolder(config.TmpFolder); err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelError, "create process temporary folder", slog.String("folder", config.TmpFolder), slog.Any("error", err))
+	} else {
+		service.tmpFolder = tmpFolder
+	}
+
+	service.tmpBudget = newTmpBudget(config.TmpFolder, service.tmpFolder, config.TmpBudget*1024*1024, config.TmpBodySize*1024*1024, config.TmpBudgetWait)
+
+	if len(config.RemoteSchemes) != 0 {
+		service.remoteSchemes = config.RemoteSchemes
+		service.remoteMaxSize = int64(config.RemoteMaxSize * 1024 * 1024)
+		service.remoteClient = newRemoteClient(config.RemoteTimeout, config.RemoteMaxRedirects, config.RemoteSchemes, config.RemoteAllowPrivate)
+	}
 
-		service.metric, err = meter.Int64Counter("vith.item")
-		if err != nil {
-			slog.LogAttrs(context.Background(), slog.LevelError, "create vith counter", slog.Any("error", err))
+	switch {
+	case len(config.CacheStorage) != 0 && storageService != nil && storageService.Enabled():
+		service.cache = storageCache{storage: storageService, folder: config.CacheStorage}
+
+	case len(config.CacheFolder) != 0:
+		if cache, err := newLocalCache(config.CacheFolder, int64(config.CacheSize*1024*1024)); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelError, "create thumbnails cache", slog.String("folder", config.CacheFolder), slog.Any("error", err))
+		} else {
+			service.cache = cache
 		}
 	}
 
+	if meterProvider != nil {
+		service.metrics = newMetrics(meterProvider.Meter("github.com/ViBiOh/vith/pkg/vith"), service.streamRequestQueue, service.tmpBudget)
+	}
+
 	if tracerProvider != nil {
 		service.tracer = tracerProvider.Tracer("vith")
 	}
 
-	return service
+	return service, nil
 }